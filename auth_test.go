@@ -0,0 +1,131 @@
+package goose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuthHeaders(t *testing.T) {
+	var auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	u.User = url.UserPassword("elastic", "changeme")
+	tests := []struct {
+		options []Option
+		should  string
+	}{
+		{nil, "Basic ZWxhc3RpYzpjaGFuZ2VtZQ=="},
+		{[]Option{WithBasicAuth("goose", "secret")}, "Basic Z29vc2U6c2VjcmV0"},
+		{[]Option{WithApiKey("VuaCfGcBCdbkQm-e5aOx", "ui2lp2axTNmsyakw9tvNnw")}, "ApiKey VnVhQ2ZHY0JDZGJrUW0tZTVhT3g6dWkybHAyYXhUTm1zeWFrdzl0dk5udw=="},
+		{[]Option{WithBearerToken("dGhpcyBpcyBub3QgYSByZWFsIHRva2Vu")}, "Bearer dGhpcyBpcyBub3QgYSByZWFsIHRva2Vu"},
+	}
+	for _, test := range tests {
+		auth = ""
+		if _, err := NewElasticSearch(u, append(test.options, WithVersion(testVersion))...); err != nil {
+			t.Fatal("Cannot create client:", err)
+		}
+		if auth != test.should {
+			t.Errorf("wrong Authorization header. Expected %q, got %q", test.should, auth)
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL + "/" + index)
+
+	if _, err := NewElasticSearch(u); err == nil {
+		t.Error("untrusted server certificate accepted")
+	}
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if _, err := NewElasticSearch(u, WithVersion(testVersion), WithCACert(ca)); err != nil {
+		t.Error("Cannot dial TLS server with its CA:", err)
+	}
+
+	if _, err := NewElasticSearch(u, WithTransport(roundTripFunc(nil)), WithCACert(ca)); err == nil {
+		t.Error("TLS options accepted with a custom RoundTripper")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	cert := writeClientCertificate(t, certFile, keyFile)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	config := &tls.Config{RootCAs: x509.NewCertPool()}
+	config.RootCAs.AddCert(ts.Certificate())
+
+	if _, err := NewElasticSearch(u, WithTLSConfig(config)); err == nil {
+		t.Error("server accepted a client without certificate")
+	}
+	if _, err := NewElasticSearch(u, WithVersion(testVersion), WithTLSConfig(config), WithClientCertificate(certFile, keyFile)); err != nil {
+		t.Error("Cannot dial TLS server with a client certificate:", err)
+	}
+	if len(config.Certificates) != 0 {
+		t.Error("TLS options modified the given TLS config")
+	}
+}
+
+// writes a self-signed client certificate and its key in PEM files
+func writeClientCertificate(t *testing.T, certFile, keyFile string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "goose"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
@@ -0,0 +1,239 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defines the states of a circuit
+type BreakerState int
+
+const (
+	BREAKER_CLOSED    = BreakerState(iota) // requests are sent
+	BREAKER_OPEN                           // requests fail fast
+	BREAKER_HALF_OPEN                      // a few trial requests are sent
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BREAKER_CLOSED:
+		return "closed"
+	case BREAKER_OPEN:
+		return "open"
+	}
+	return "half-open"
+}
+
+// Defines what a circuit protects
+type BreakerScope int
+
+const (
+	BREAKER_PER_NODE      = BreakerScope(iota) // one circuit per ES node URL
+	BREAKER_PER_OPERATION                      // one circuit per operation name (Insert, Search, ...)
+)
+
+// CircuitBreaker stops sending requests to a degraded cluster. Each circuit
+// starts closed and opens after FailureThreshold consecutive failures. While
+// open, requests fail immediately with a *CircuitOpenError. Once OpenTimeout
+// is over, the circuit is half-open: up to HalfOpenRequests trial requests are
+// sent, the first one to succeed closes the circuit while a failure opens it
+// again.
+//
+// Circuits are kept per node or per operation, depending on Scope. With
+// several nodes and a per node scope, requests go to the nodes whose circuit
+// is not open.
+type CircuitBreaker struct {
+	FailureThreshold int           // Consecutive failures opening a circuit
+	OpenTimeout      time.Duration // Time spent open before trial requests
+	HalfOpenRequests int           // Max concurrent trial requests
+	Scope            BreakerScope
+
+	// IsFailure reports whether a request which got resp or err is a
+	// failure. If nil, transport errors, 429 and 5xx responses are.
+	// Canceled requests are neither failures nor successes: they leave
+	// the circuit as it is.
+	IsFailure func(resp *http.Response, err error) bool
+
+	// OnStateChange, if not nil, is called when the circuit of key changes
+	// from state to state. It must not call the methods of the breaker.
+	OnStateChange func(key string, from, to BreakerState)
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// circuit is the state of the requests to a node or of an operation.
+type circuit struct {
+	state      BreakerState
+	failures   int       // consecutive failures while closed
+	openUntil  time.Time // end of the open state
+	trials     int       // trial requests in flight while half-open
+	generation int       // incremented on every state change
+}
+
+// NewCircuitBreaker returns a per node CircuitBreaker opening circuits after
+// threshold consecutive failures for timeout, with one trial request.
+func NewCircuitBreaker(threshold int, timeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureThreshold: threshold,
+		OpenTimeout:      timeout,
+		HalfOpenRequests: 1,
+	}
+}
+
+// CircuitOpenError is returned for requests not sent because their circuit
+// is open.
+type CircuitOpenError struct {
+	Key     string    // Node URL or operation name
+	RetryAt time.Time // End of the open state
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open for %s until %s", e.Key, e.RetryAt.Format(time.RFC3339))
+}
+
+// IsCircuitOpen returns true if err is due to an open circuit.
+func IsCircuitOpen(err error) bool {
+	var e *CircuitOpenError
+	return errors.As(err, &e)
+}
+
+// State returns the state of the circuit of key, a node URL or an operation
+// name. Unknown circuits are closed.
+func (cb *CircuitBreaker) State(key string) BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	c, ok := cb.circuits[key]
+	if !ok {
+		return BREAKER_CLOSED
+	}
+	cb.expire(key, c, time.Now())
+	return c.state
+}
+
+// States returns the state of every known circuit, for health checks.
+func (cb *CircuitBreaker) States() map[string]BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	now := time.Now()
+	states := make(map[string]BreakerState, len(cb.circuits))
+	for key, c := range cb.circuits {
+		cb.expire(key, c, now)
+		states[key] = c.state
+	}
+	return states
+}
+
+// Reset closes every circuit.
+func (cb *CircuitBreaker) Reset() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.circuits = nil
+}
+
+// allow reserves the sending of a request on the circuit of key. It returns
+// the generation of the circuit, to give to done, or a *CircuitOpenError.
+func (cb *CircuitBreaker) allow(key string) (int, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.circuits == nil {
+		cb.circuits = make(map[string]*circuit)
+	}
+	c, ok := cb.circuits[key]
+	if !ok {
+		c = new(circuit)
+		cb.circuits[key] = c
+	}
+	cb.expire(key, c, time.Now())
+	switch c.state {
+	case BREAKER_OPEN:
+		return 0, &CircuitOpenError{Key: key, RetryAt: c.openUntil}
+	case BREAKER_HALF_OPEN:
+		max := cb.HalfOpenRequests
+		if max < 1 {
+			max = 1
+		}
+		if c.trials >= max {
+			return 0, &CircuitOpenError{Key: key, RetryAt: time.Now()}
+		}
+		c.trials++
+	}
+	return c.generation, nil
+}
+
+// done records the result of a request allowed on the circuit of key during
+// generation. Results of requests sent before the last state change are
+// ignored. A canceled request only releases its trial slot.
+func (cb *CircuitBreaker) done(key string, generation int, resp *http.Response, err error) {
+	canceled := errors.Is(err, context.Canceled)
+	failed := !canceled && cb.failed(resp, err)
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	c, ok := cb.circuits[key]
+	if !ok || c.generation != generation {
+		return
+	}
+	switch c.state {
+	case BREAKER_CLOSED:
+		if canceled {
+			return
+		}
+		if !failed {
+			c.failures = 0
+			return
+		}
+		c.failures++
+		if c.failures >= cb.FailureThreshold {
+			cb.open(key, c)
+		}
+	case BREAKER_HALF_OPEN:
+		c.trials--
+		if canceled {
+			return
+		}
+		if failed {
+			cb.open(key, c)
+		} else {
+			cb.setState(key, c, BREAKER_CLOSED)
+		}
+	}
+}
+
+// failed reports whether a request which got resp or err is a failure.
+func (cb *CircuitBreaker) failed(resp *http.Response, err error) bool {
+	if cb.IsFailure != nil {
+		return cb.IsFailure(resp, err)
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// open opens c for the open timeout.
+func (cb *CircuitBreaker) open(key string, c *circuit) {
+	c.openUntil = time.Now().Add(cb.OpenTimeout)
+	cb.setState(key, c, BREAKER_OPEN)
+}
+
+// expire turns c half-open if its open timeout is over.
+func (cb *CircuitBreaker) expire(key string, c *circuit, now time.Time) {
+	if c.state == BREAKER_OPEN && !now.Before(c.openUntil) {
+		cb.setState(key, c, BREAKER_HALF_OPEN)
+	}
+}
+
+func (cb *CircuitBreaker) setState(key string, c *circuit, state BreakerState) {
+	from := c.state
+	c.state = state
+	c.failures = 0
+	c.trials = 0
+	c.generation++
+	if cb.OnStateChange != nil {
+		cb.OnStateChange(key, from, state)
+	}
+}
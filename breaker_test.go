@@ -0,0 +1,136 @@
+package goose
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerPerOperation(t *testing.T) {
+	var calls, healthy atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if healthy.Load() == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	var changes []BreakerState
+	cb := NewCircuitBreaker(2, 50*time.Millisecond)
+	cb.Scope = BREAKER_PER_OPERATION
+	cb.OnStateChange = func(key string, from, to BreakerState) {
+		changes = append(changes, to)
+	}
+	u, _ := url.Parse(ts.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithIndexCreation(false), WithCircuitBreaker(cb), WithRetryPolicy(newRetryPolicy()))
+
+	for i := 0; i < 2; i++ {
+		if err := es.OpenIndex(); err == nil || IsCircuitOpen(err) {
+			t.Fatal("expected a 503 error, got", err)
+		}
+	}
+	if s := cb.State("OpenIndex"); s != BREAKER_OPEN {
+		t.Fatalf("circuit should be open, got %v", s)
+	}
+	if err := es.OpenIndex(); !IsCircuitOpen(err) {
+		t.Error("expected a circuit open error, got", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("requests sent while the circuit is open: %d calls", calls.Load())
+	}
+	if s := cb.State("CloseIndex"); s != BREAKER_CLOSED {
+		t.Errorf("circuit of another operation is %v", s)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if s := cb.States()["OpenIndex"]; s != BREAKER_HALF_OPEN {
+		t.Fatalf("circuit should be half-open, got %v", s)
+	}
+	healthy.Store(1)
+	if err := es.OpenIndex(); err != nil {
+		t.Fatal("trial request failed:", err)
+	}
+	if s := cb.State("OpenIndex"); s != BREAKER_CLOSED {
+		t.Errorf("circuit should be closed, got %v", s)
+	}
+	should := []BreakerState{BREAKER_OPEN, BREAKER_HALF_OPEN, BREAKER_CLOSED}
+	if len(changes) != len(should) || changes[0] != should[0] || changes[1] != should[1] || changes[2] != should[2] {
+		t.Errorf("wrong state changes. Expected %v, got %v", should, changes)
+	}
+}
+
+func TestCircuitBreakerPerNode(t *testing.T) {
+	var badCalls, goodCalls int
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		badCalls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		goodCalls++
+	}))
+	defer good.Close()
+
+	cb := NewCircuitBreaker(1, time.Minute)
+	u, _ := url.Parse(bad.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithIndexCreation(false), WithNodes(bad.URL, good.URL), WithCircuitBreaker(cb))
+
+	failures := 0
+	for i := 0; i < 6; i++ {
+		if err := es.OpenIndex(); err != nil {
+			failures++
+		}
+	}
+	if badCalls != 1 || failures != 1 || goodCalls != 5 {
+		t.Errorf("open node not skipped: %d bad calls, %d failures, %d good calls", badCalls, failures, goodCalls)
+	}
+	if s := cb.State(bad.URL); s != BREAKER_OPEN {
+		t.Errorf("circuit of the failing node should be open, got %v", s)
+	}
+
+	cb.Reset()
+	if len(cb.States()) != 0 {
+		t.Error("Reset did not close the circuits")
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	cb := NewCircuitBreaker(1, time.Millisecond)
+	failure := &http.Response{StatusCode: http.StatusServiceUnavailable}
+	success := &http.Response{StatusCode: http.StatusOK}
+
+	stale, _ := cb.allow("n")
+	g, _ := cb.allow("n")
+	cb.done("n", g, failure, nil)
+	time.Sleep(2 * time.Millisecond)
+
+	g, err := cb.allow("n")
+	if err != nil {
+		t.Fatal("trial request rejected:", err)
+	}
+	if _, err := cb.allow("n"); !IsCircuitOpen(err) {
+		t.Error("more trial requests than HalfOpenRequests allowed")
+	}
+	// results of requests sent before the circuit opened are ignored
+	cb.done("n", stale, success, nil)
+	if s := cb.State("n"); s != BREAKER_HALF_OPEN {
+		t.Errorf("stale result changed the circuit to %v", s)
+	}
+	// a canceled trial request only releases its slot
+	cb.done("n", g, nil, context.Canceled)
+	if s := cb.State("n"); s != BREAKER_HALF_OPEN {
+		t.Errorf("canceled trial request changed the circuit to %v", s)
+	}
+	if g, err = cb.allow("n"); err != nil {
+		t.Fatal("trial slot of the canceled request not released:", err)
+	}
+	cb.done("n", g, failure, nil)
+	if s := cb.State("n"); s != BREAKER_OPEN {
+		t.Errorf("failed trial request should open the circuit, got %v", s)
+	}
+}
@@ -0,0 +1,366 @@
+package goose
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Actions of the bulk API.
+const (
+	BULK_INDEX  = "index"
+	BULK_CREATE = "create"
+	BULK_UPDATE = "update"
+	BULK_DELETE = "delete"
+)
+
+// bulkAction is an action of a bulk request.
+type bulkAction struct {
+	op     string
+	object ElasticObject
+	ub     *UpdateBuilder // update actions only
+}
+
+// BulkBuilder has helper functions to build requests of the ES bulk API,
+// which executes several index, create, update or delete actions at once.
+// The objects of a request can be of different types.
+//
+// For example:
+//
+//	bb := NewBulkBuilder().
+//	    AddIndex(hq).
+//	    AddUpdate(counter, NewUpdateBuilder().SetScript("ctx._source.n += 1", nil)).
+//	    AddDelete(old)
+//	res, err := es.Bulk(bb)
+type BulkBuilder struct {
+	actions []bulkAction
+	refresh RefreshPolicy
+}
+
+// Returns a pointer to a new BulkBuilder
+func NewBulkBuilder() *BulkBuilder {
+	return new(BulkBuilder)
+}
+
+// AddIndex adds the indexing of object, which replaces any document with
+// the same key. The write is conditioned on the version of Versioned
+// objects.
+func (bb *BulkBuilder) AddIndex(object ElasticObject) *BulkBuilder {
+	bb.actions = append(bb.actions, bulkAction{op: BULK_INDEX, object: object})
+	return bb
+}
+
+// AddCreate adds the creation of object, which fails if a document with the
+// same key exists.
+func (bb *BulkBuilder) AddCreate(object ElasticObject) *BulkBuilder {
+	bb.actions = append(bb.actions, bulkAction{op: BULK_CREATE, object: object})
+	return bb
+}
+
+// AddUpdate adds the update of object as defined by ub, like UpdateWith
+// does. A nil ub merges object. Returning the updated source is not
+// supported.
+func (bb *BulkBuilder) AddUpdate(object ElasticObject, ub *UpdateBuilder) *BulkBuilder {
+	if ub == nil {
+		ub = NewUpdateBuilder()
+	}
+	bb.actions = append(bb.actions, bulkAction{op: BULK_UPDATE, object: object, ub: ub})
+	return bb
+}
+
+// AddDelete adds the deletion of object. The deletion is conditioned on the
+// version of Versioned objects.
+func (bb *BulkBuilder) AddDelete(object ElasticObject) *BulkBuilder {
+	bb.actions = append(bb.actions, bulkAction{op: BULK_DELETE, object: object})
+	return bb
+}
+
+// SetRefresh sets the refresh policy of the request, the default one of the
+// instance if not set.
+func (bb *BulkBuilder) SetRefresh(p RefreshPolicy) *BulkBuilder {
+	bb.refresh = p
+	return bb
+}
+
+// Len returns the number of actions of the request.
+func (bb *BulkBuilder) Len() int {
+	return len(bb.actions)
+}
+
+// BulkResponse is the reply of a bulk request.
+type BulkResponse struct {
+	Took   int        // in milliseconds
+	Errors bool       // true if an action failed
+	Items  []BulkItem // results of the actions, in the order of the request
+}
+
+// BulkItem is the result of an action of a bulk request.
+type BulkItem struct {
+	Action  string // BULK_INDEX, BULK_CREATE, BULK_UPDATE or BULK_DELETE
+	Object  ElasticObject
+	Index   string
+	Type    string // empty since ES 8.x
+	Id      string
+	Status  int    // HTTP status code of the action
+	Result  string // created, updated, deleted, noop or not_found, since ES 5.x
+	Version DocVersion
+	Err     error // *Error if the action failed
+}
+
+// Failed returns the items of the actions which failed.
+func (br *BulkResponse) Failed() []BulkItem {
+	var failed []BulkItem
+	for _, item := range br.Items {
+		if item.Err != nil {
+			failed = append(failed, item)
+		}
+	}
+	return failed
+}
+
+// Err returns an error wrapping the error of the first failed action, nil
+// if every action succeeded.
+func (br *BulkResponse) Err() error {
+	failed := br.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d bulk actions failed, first on %s: %w", len(failed), len(br.Items), failed[0].Id, failed[0].Err)
+}
+
+// bulkItemReply is the reply of an action of a bulk request.
+type bulkItemReply struct {
+	result
+	Status int             `json:"status"`
+	Result string          `json:"result"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// item returns the BulkItem of the reply to the action a.
+func (r *bulkItemReply) item(a bulkAction) BulkItem {
+	item := BulkItem{
+		Action:  a.op,
+		Object:  a.object,
+		Index:   r.Index,
+		Type:    r.Type,
+		Id:      r.Id,
+		Status:  r.Status,
+		Result:  r.Result,
+		Version: r.docVersion(),
+	}
+	if len(r.Error) > 0 && string(r.Error) != "null" {
+		body, _ := json.Marshal(M{"_index": r.Index, "error": r.Error})
+		item.Err = parseError(r.Status, body)
+	}
+	return item
+}
+
+// bulkMeta returns the metadata line of the action a for ES version v.
+func (se *ElasticSearch) bulkMeta(ctx context.Context, v Version, a bulkAction) (M, error) {
+	if a.op == BULK_UPDATE {
+		if err := a.ub.checkVersioned(a.object); err != nil {
+			return nil, err
+		}
+	}
+	meta := M{"_id": a.object.Key()}
+	if v.Dialect() < DIALECT_8X {
+		path, err := buildPath(a.object)
+		if err != nil {
+			return nil, err
+		}
+		meta["_type"] = strings.TrimSuffix(path, "/")
+	} else {
+		name, err := se.indexName(a.object)
+		if err != nil {
+			return nil, err
+		}
+		meta["_index"] = name
+	}
+	// metadata fields lost their underscore in ES 7.x
+	prefix := ""
+	if v.Major < 7 {
+		prefix = "_"
+	}
+	if a.op != BULK_CREATE {
+		params := make(url.Values)
+		if err := se.versionParams(ctx, a.object, params); err != nil {
+			return nil, err
+		}
+		if version := params.Get("version"); version != "" {
+			meta[prefix+"version"] = json.Number(version)
+		}
+		for _, key := range []string{"if_seq_no", "if_primary_term"} {
+			if n := params.Get(key); n != "" {
+				meta[key] = json.Number(n)
+			}
+		}
+	}
+	r, p := routing(v, a.object)
+	if r != "" {
+		meta[prefix+"routing"] = r
+	}
+	if p != "" {
+		meta[prefix+"parent"] = p
+	}
+	if a.op == BULK_UPDATE && a.ub.retryOnConflict > 0 {
+		meta[prefix+"retry_on_conflict"] = a.ub.retryOnConflict
+	}
+	return M{a.op: meta}, nil
+}
+
+// executes the actions of bb with a single request. Actions fail
+// independently: the error is only set if the request itself failed, and
+// the failures of actions are reported by the response (see
+// BulkResponse.Err). The version of Versioned objects is updated after
+// every successful action.
+func (se *ElasticSearch) Bulk(bb *BulkBuilder) (*BulkResponse, error) {
+	return se.BulkContext(context.Background(), bb)
+}
+
+// BulkContext is like Bulk but uses ctx to bound the request.
+func (se *ElasticSearch) BulkContext(ctx context.Context, bb *BulkBuilder) (*BulkResponse, error) {
+	return se.bulk(ctx, "Bulk", bb, se.retry)
+}
+
+// bulk sends the bulk request of bb as the operation op, which names it in
+// metrics and circuit breakers, retrying it according to retry, which may be
+// nil.
+func (se *ElasticSearch) bulk(ctx context.Context, op string, bb *BulkBuilder, retry *RetryPolicy) (*BulkResponse, error) {
+	if bb == nil || len(bb.actions) == 0 {
+		return nil, errors.New("no bulk action")
+	}
+	v, err := se.VersionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, a := range bb.actions {
+		meta, err := se.bulkMeta(ctx, v, a)
+		if err != nil {
+			return nil, err
+		}
+		if err = enc.Encode(meta); err != nil {
+			return nil, err
+		}
+		// the source on the next line, but for deletions
+		switch a.op {
+		case BULK_INDEX, BULK_CREATE:
+			err = enc.Encode(a.object)
+		case BULK_UPDATE:
+			var body M
+			if body, err = a.ub.body(v, a.object); err == nil {
+				err = enc.Encode(body)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	params := make(url.Values)
+	se.refreshParams(v, bb.refresh, params, true)
+	resp, err := se.send(ctx, op, POST, addQuery(se.basePath+actionBulk, params.Encode()), &buf, false, retry)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Took   int                        `json:"took"`
+		Errors bool                       `json:"errors"`
+		Items  []map[string]bulkItemReply `json:"items"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	if len(res.Items) != len(bb.actions) {
+		return nil, errors.New("unexpected number of items in bulk reply")
+	}
+	br := &BulkResponse{Took: res.Took, Errors: res.Errors, Items: make([]BulkItem, len(res.Items))}
+	for i, a := range bb.actions {
+		reply := res.Items[i][a.op]
+		br.Items[i] = reply.item(a)
+		if vo, ok := a.object.(Versioned); ok && br.Items[i].Err == nil {
+			vo.SetDocVersion(br.Items[i].Version)
+		}
+	}
+	return br, nil
+}
+
+// executes the actions of bb one after the other. Actions fail
+// independently: the error is only set if ctx is done, and the failures of
+// actions are reported by the response (see BulkResponse.Err). The version
+// of Versioned objects is updated after every successful action.
+func (me *MemoryEngine) Bulk(bb *BulkBuilder) (*BulkResponse, error) {
+	return me.BulkContext(context.Background(), bb)
+}
+
+// BulkContext is like Bulk but returns ctx.Err() if ctx is done.
+func (me *MemoryEngine) BulkContext(ctx context.Context, bb *BulkBuilder) (*BulkResponse, error) {
+	if bb == nil || len(bb.actions) == 0 {
+		return nil, errors.New("no bulk action")
+	}
+	// like ES, invalid updates fail the whole bulk before any action
+	for _, a := range bb.actions {
+		if a.op == BULK_UPDATE {
+			if err := a.ub.checkVersioned(a.object); err != nil {
+				return nil, err
+			}
+		}
+	}
+	br := &BulkResponse{Items: make([]BulkItem, len(bb.actions))}
+	for i, a := range bb.actions {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		path, err := buildPath(a.object)
+		if err != nil {
+			return nil, err
+		}
+		key := a.object.Key()
+		me.mu.RLock()
+		_, existed := me.types[path][key]
+		me.mu.RUnlock()
+		item := BulkItem{Action: a.op, Object: a.object, Type: strings.TrimSuffix(path, "/"), Id: key, Status: 200}
+		switch a.op {
+		case BULK_INDEX:
+			err = me.InsertContext(ctx, a.object)
+			item.Result = "updated"
+		case BULK_CREATE:
+			err = me.CreateContext(ctx, a.object)
+		case BULK_UPDATE:
+			err = me.UpdateWithContext(ctx, a.object, a.ub)
+			item.Result = "updated"
+		case BULK_DELETE:
+			err = me.DeleteContext(ctx, a.object)
+			item.Result = "deleted"
+		}
+		if !existed && a.op != BULK_DELETE {
+			item.Status, item.Result = 201, "created"
+		}
+		if !existed && a.op == BULK_DELETE && IsNotFound(err) {
+			// like ES, deleting a missing document is not an error
+			item.Status, item.Result, err = 404, "not_found", nil
+		}
+		if err != nil {
+			item.Status, item.Result, item.Err = 0, "", err
+			if e, ok := asError(err); ok {
+				item.Status = e.Status
+			}
+			br.Errors = true
+		} else if a.op != BULK_DELETE {
+			me.mu.RLock()
+			if d, ok := me.types[path][key]; ok {
+				item.Version = d.docVersion()
+			}
+			me.mu.RUnlock()
+		} else if vo, ok := a.object.(Versioned); ok {
+			item.Version = vo.DocVersion()
+		}
+		br.Items[i] = item
+	}
+	return br, nil
+}
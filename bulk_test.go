@@ -0,0 +1,93 @@
+package goose
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gotsunami/goose/goosetest"
+)
+
+// testBulk runs mixed bulk actions on objects of several types with engine.
+func testBulk(t *testing.T, engine SearchEngine) {
+	c := &counter{Name: "c", N: 1}
+	for _, object := range []ElasticObject{&scoreboard{Name: "bob", Points: 1}, c} {
+		if err := engine.Insert(object); err != nil {
+			t.Fatalf("Cannot insert: %v", err)
+		}
+	}
+	bb := NewBulkBuilder().
+		AddIndex(&DummyObject{Id: 5, Description: "five"}).
+		AddCreate(&scoreboard{Name: "bob"}).
+		AddUpdate(&scoreboard{Name: "bob"}, NewUpdateBuilder().SetDoc(M{"points": 2}).SetRetryOnConflict(2)).
+		AddDelete(&DummyObject{Id: 6}).
+		AddCreate(&scoreboard{Name: "eve", Points: 1}).
+		AddUpdate(c, NewUpdateBuilder().SetScript("ctx._source.n += 1", nil))
+	if bb.Len() != 6 {
+		t.Errorf("expected 6 actions, got %d", bb.Len())
+	}
+	res, err := engine.Bulk(bb)
+	if err != nil {
+		t.Fatalf("Cannot bulk: %v", err)
+	}
+	var statuses []int
+	for _, item := range res.Items {
+		statuses = append(statuses, item.Status)
+	}
+	if should := []int{201, 409, 200, 404, 201, 200}; !reflect.DeepEqual(statuses, should) {
+		t.Errorf("expected the statuses %v, got %v", should, statuses)
+	}
+	if failed := res.Failed(); !res.Errors || len(failed) != 1 || !IsConflict(failed[0].Err) || failed[0].Id != "bob" {
+		t.Errorf("expected a conflict on bob, got %+v", failed)
+	}
+	if !IsConflict(res.Err()) {
+		t.Errorf("expected a conflict, got %v", res.Err())
+	}
+	if item := res.Items[2]; item.Action != BULK_UPDATE || item.Id != "bob" || item.Version.Version != 2 {
+		t.Errorf("bad update item %+v", item)
+	}
+	if c.DocVersion().Version != 2 {
+		t.Errorf("version of c not updated: %+v", c.DocVersion())
+	}
+
+	dummy, sb := &DummyObject{Id: 5}, &scoreboard{Name: "bob"}
+	if found, err := engine.Get(dummy); !found || dummy.Description != "five" {
+		t.Errorf("indexed object not found (%v)", err)
+	}
+	if _, err := engine.Get(sb); err != nil || sb.Points != 2 {
+		t.Errorf("expected 2 points, got %+v (%v)", sb, err)
+	}
+	if _, err := engine.Get(c); err != nil || c.N != 2 {
+		t.Errorf("expected the counter 2, got %d (%v)", c.N, err)
+	}
+
+	// stale objects make BulkInsert fail
+	c.SetDocVersion(DocVersion{Version: 99, SeqNo: 99, PrimaryTerm: 1})
+	if err := engine.BulkInsert([]ElasticObject{&scoreboard{Name: "zoe"}, c}); !IsConflict(err) {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+	if _, err := engine.Bulk(NewBulkBuilder()); err == nil {
+		t.Error("expected an error without actions")
+	}
+}
+
+func TestBulk(t *testing.T) {
+	forEachEngine(t, testEngines, func(t *testing.T, s *goosetest.Server, engine SearchEngine) {
+		testBulk(t, engine)
+		if s != nil {
+			s.AssertRequestCount(t, "POST", "/"+index+"/_bulk", 2)
+		}
+	}, perTypeIndex)
+}
+
+func TestBulkInsertOperation(t *testing.T) {
+	mm := NewMemoryMetrics()
+	forEachEngine(t, []string{"7.10.2"}, func(t *testing.T, _ *goosetest.Server, engine SearchEngine) {
+		if err := engine.BulkInsert([]ElasticObject{&DummyObject{Id: 1}, &DummyObject{Id: 2}}); err != nil {
+			t.Fatal("Cannot bulk insert:", err)
+		}
+		snapshot := mm.Snapshot()
+		if snapshot["BulkInsert"].Count != 1 || snapshot["Bulk"].Count != 0 {
+			t.Errorf("expected a BulkInsert operation, got %v", snapshot)
+		}
+	}, WithMetrics(mm))
+}
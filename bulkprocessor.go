@@ -0,0 +1,399 @@
+package goose
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BulkProcessorConfig defines when a BulkProcessor flushes its actions and
+// how it handles failures. Zero values select the defaults.
+type BulkProcessorConfig struct {
+	Workers       int           // Number of concurrent bulk requests, 1 by default
+	MaxActions    int           // Flush once this many actions are pending, 1000 by default
+	MaxBytes      int           // Flush once the pending actions reach this estimated size, 5MB by default
+	FlushInterval time.Duration // Flush the pending actions at least this often, never if 0
+	Refresh       RefreshPolicy // Of the bulk requests, the default one of the instance if empty
+
+	// Retry defines the retries of the actions rejected by ES because it
+	// is overloaded (429 errors), and of the bulk requests failing as a
+	// whole with a transient error. Like other POST requests, bulk requests
+	// which may have been executed (i.e 503 errors) are only sent again if
+	// RetryNonIdempotent is set. NewRetryPolicy(5) is used if nil. It
+	// replaces the retry policy of the instance, which does not apply to
+	// the requests of the processor.
+	Retry *RetryPolicy
+
+	// OnFailure is called with the items of the actions which failed,
+	// after their retries. The error of the items of a bulk request which
+	// failed as a whole is the one of the request. It is called from a
+	// goroutine of its own, one item after the other, so that it can add
+	// actions to the processor. It must not call Flush or Close, which
+	// wait for the failures to be reported.
+	OnFailure func(BulkItem)
+}
+
+const (
+	defaultBulkActions = 1000
+	defaultBulkBytes   = 5 << 20
+)
+
+// ErrBulkProcessorClosed is returned when an action is added to a closed
+// BulkProcessor.
+var ErrBulkProcessorClosed = errors.New("bulk processor closed")
+
+// BulkProcessor batches bulk actions added from any goroutine, and sends
+// them in the background with bulk requests when enough of them are pending
+// or periodically. Actions rejected by an overloaded cluster are retried
+// with an exponential backoff, and the failures are reported to a callback.
+//
+// A BulkProcessor must be closed to send the last pending actions:
+//
+//	bp := es.NewBulkProcessor(goose.BulkProcessorConfig{
+//	    Workers:       4,
+//	    FlushInterval: time.Second,
+//	    OnFailure:     func(item goose.BulkItem) { log.Println(item.Id, item.Err) },
+//	})
+//	defer bp.Close()
+//	for _, hq := range hqs {
+//	    bp.AddIndex(hq)
+//	}
+type BulkProcessor struct {
+	se     *ElasticSearch
+	cfg    BulkProcessorConfig
+	ctx    context.Context // bounds the requests and the backoffs
+	cancel context.CancelFunc
+	work   chan *BulkBuilder
+	done   chan struct{} // closed by Close to stop the periodic flushes
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	idle   *sync.Cond // signaled when there are no more requests in flight
+	batch  *BulkBuilder
+	bytes  int
+	// number of batches handed to the workers and not processed yet
+	inflight int
+	closed   bool
+	// failed items waiting for OnFailure, and the number of items queued
+	// or being reported
+	failures   []BulkItem
+	reports    int
+	failed     *sync.Cond // signaled when failures are queued or the processor stops
+	stopped    bool
+	reporterWg sync.WaitGroup
+}
+
+// NewBulkProcessor starts a BulkProcessor sending its bulk requests to se.
+func (se *ElasticSearch) NewBulkProcessor(cfg BulkProcessorConfig) *BulkProcessor {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.MaxActions < 1 {
+		cfg.MaxActions = defaultBulkActions
+	}
+	if cfg.MaxBytes < 1 {
+		cfg.MaxBytes = defaultBulkBytes
+	}
+	if cfg.Retry == nil {
+		cfg.Retry = NewRetryPolicy(5)
+	}
+	bp := &BulkProcessor{
+		se:    se,
+		cfg:   cfg,
+		work:  make(chan *BulkBuilder),
+		done:  make(chan struct{}),
+		batch: NewBulkBuilder(),
+	}
+	bp.ctx, bp.cancel = context.WithCancel(context.Background())
+	bp.idle = sync.NewCond(&bp.mu)
+	bp.failed = sync.NewCond(&bp.mu)
+	if cfg.OnFailure != nil {
+		bp.reporterWg.Add(1)
+		go bp.reporter()
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		bp.wg.Add(1)
+		go bp.worker()
+	}
+	if cfg.FlushInterval > 0 {
+		bp.wg.Add(1)
+		go bp.ticker()
+	}
+	return bp
+}
+
+// AddIndex adds the indexing of object, like BulkBuilder.AddIndex.
+func (bp *BulkProcessor) AddIndex(object ElasticObject) error {
+	return bp.add(bulkAction{op: BULK_INDEX, object: object}, object)
+}
+
+// AddCreate adds the creation of object, like BulkBuilder.AddCreate.
+func (bp *BulkProcessor) AddCreate(object ElasticObject) error {
+	return bp.add(bulkAction{op: BULK_CREATE, object: object}, object)
+}
+
+// AddUpdate adds the update of object as defined by ub, like
+// BulkBuilder.AddUpdate.
+func (bp *BulkProcessor) AddUpdate(object ElasticObject, ub *UpdateBuilder) error {
+	if ub == nil {
+		ub = NewUpdateBuilder()
+	}
+	var source interface{} = object
+	switch {
+	case ub.script != nil:
+		source = ub.script
+	case ub.doc != nil:
+		source = ub.doc
+	}
+	return bp.add(bulkAction{op: BULK_UPDATE, object: object, ub: ub}, source)
+}
+
+// AddDelete adds the deletion of object, like BulkBuilder.AddDelete.
+func (bp *BulkProcessor) AddDelete(object ElasticObject) error {
+	return bp.add(bulkAction{op: BULK_DELETE, object: object}, nil)
+}
+
+// add adds the action a, whose source line is estimated from source, and
+// hands the pending actions to the workers if there are enough of them. It
+// blocks while all the workers are busy.
+func (bp *BulkProcessor) add(a bulkAction, source interface{}) error {
+	// the metadata line takes about 100 bytes
+	size := 100 + len(a.object.Key())
+	if source != nil {
+		b, err := json.Marshal(source)
+		if err != nil {
+			return err
+		}
+		size += len(b)
+	}
+	bp.mu.Lock()
+	if bp.closed {
+		bp.mu.Unlock()
+		return ErrBulkProcessorClosed
+	}
+	bp.batch.actions = append(bp.batch.actions, a)
+	bp.bytes += size
+	var batch *BulkBuilder
+	if bp.batch.Len() >= bp.cfg.MaxActions || bp.bytes >= bp.cfg.MaxBytes {
+		batch = bp.take()
+	}
+	bp.mu.Unlock()
+	bp.send(batch)
+	return nil
+}
+
+// take returns the pending actions, nil if there is none, and counts them
+// as in flight. The caller must hold the lock.
+func (bp *BulkProcessor) take() *BulkBuilder {
+	if bp.batch.Len() == 0 {
+		return nil
+	}
+	batch := bp.batch
+	bp.batch, bp.bytes = NewBulkBuilder(), 0
+	bp.inflight++
+	return batch
+}
+
+// send hands batch to the workers, if not nil.
+func (bp *BulkProcessor) send(batch *BulkBuilder) {
+	if batch != nil {
+		bp.work <- batch
+	}
+}
+
+// Flush sends the pending actions and waits until every action added so far
+// has been processed, retries included.
+func (bp *BulkProcessor) Flush() error {
+	bp.mu.Lock()
+	if bp.closed {
+		bp.mu.Unlock()
+		return ErrBulkProcessorClosed
+	}
+	batch := bp.take()
+	bp.mu.Unlock()
+	bp.send(batch)
+	bp.wait()
+	return nil
+}
+
+// wait waits until there are no more requests in flight nor failures to
+// report.
+func (bp *BulkProcessor) wait() {
+	bp.mu.Lock()
+	for bp.inflight > 0 || bp.reports > 0 {
+		bp.idle.Wait()
+	}
+	bp.mu.Unlock()
+}
+
+// Close sends the pending actions, waits until every action has been
+// processed and stops the processor. Actions cannot be added anymore.
+func (bp *BulkProcessor) Close() error {
+	return bp.CloseContext(context.Background())
+}
+
+// CloseContext is like Close but stops waiting for the pending actions once
+// ctx is done: the requests in flight and the retries are then aborted, the
+// actions not processed yet are reported as failed, and ctx.Err() is
+// returned.
+func (bp *BulkProcessor) CloseContext(ctx context.Context) error {
+	bp.mu.Lock()
+	if bp.closed {
+		bp.mu.Unlock()
+		return ErrBulkProcessorClosed
+	}
+	bp.closed = true
+	batch := bp.take()
+	bp.mu.Unlock()
+	close(bp.done)
+	idle := make(chan struct{})
+	go func() {
+		bp.send(batch)
+		bp.wait()
+		close(idle)
+	}()
+	var err error
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		err = ctx.Err()
+		bp.cancel()
+		<-idle
+	}
+	bp.cancel()
+	close(bp.work)
+	bp.wg.Wait()
+	bp.mu.Lock()
+	bp.stopped = true
+	bp.failed.Signal()
+	bp.mu.Unlock()
+	bp.reporterWg.Wait()
+	return err
+}
+
+// ticker flushes the pending actions every FlushInterval, until the
+// processor is closed.
+func (bp *BulkProcessor) ticker() {
+	defer bp.wg.Done()
+	t := time.NewTicker(bp.cfg.FlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			bp.mu.Lock()
+			batch := bp.take()
+			bp.mu.Unlock()
+			bp.send(batch)
+		case <-bp.done:
+			return
+		}
+	}
+}
+
+// worker sends the batches of actions until the processor is closed.
+func (bp *BulkProcessor) worker() {
+	defer bp.wg.Done()
+	for batch := range bp.work {
+		bp.process(batch)
+		bp.mu.Lock()
+		bp.inflight--
+		if bp.inflight == 0 && bp.reports == 0 {
+			bp.idle.Broadcast()
+		}
+		bp.mu.Unlock()
+	}
+}
+
+// reporter calls OnFailure with the failed items, until the processor is
+// stopped. Reporting the failures out of the workers lets OnFailure add
+// actions while every worker is busy.
+func (bp *BulkProcessor) reporter() {
+	defer bp.reporterWg.Done()
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	for {
+		for len(bp.failures) == 0 && !bp.stopped {
+			bp.failed.Wait()
+		}
+		if len(bp.failures) == 0 {
+			return
+		}
+		items := bp.failures
+		bp.failures = nil
+		bp.mu.Unlock()
+		for _, item := range items {
+			bp.cfg.OnFailure(item)
+		}
+		bp.mu.Lock()
+		bp.reports -= len(items)
+		if bp.inflight == 0 && bp.reports == 0 {
+			bp.idle.Broadcast()
+		}
+	}
+}
+
+// process sends a batch of actions, retrying the rejected ones and the
+// requests failing with a transient error, and reports the failures.
+func (bp *BulkProcessor) process(batch *BulkBuilder) {
+	for attempt := 1; ; attempt++ {
+		// cfg.Retry replaces the retry policy of the instance
+		res, err := bp.se.bulk(bp.ctx, "Bulk", batch.SetRefresh(bp.cfg.Refresh), nil)
+		if err != nil {
+			if bp.retryable(err, attempt) && sleepContext(bp.ctx, bp.cfg.Retry.backoff(attempt)) == nil {
+				continue
+			}
+			for _, a := range batch.actions {
+				bp.fail(BulkItem{Action: a.op, Object: a.object, Id: a.object.Key(), Err: err})
+			}
+			return
+		}
+		retries := NewBulkBuilder()
+		var rejected []BulkItem
+		for i, item := range res.Items {
+			switch {
+			case item.Status == http.StatusTooManyRequests && attempt < bp.cfg.Retry.MaxAttempts:
+				retries.actions = append(retries.actions, batch.actions[i])
+				rejected = append(rejected, item)
+			case item.Err != nil:
+				bp.fail(item)
+			}
+		}
+		if retries.Len() == 0 {
+			return
+		}
+		if sleepContext(bp.ctx, bp.cfg.Retry.backoff(attempt)) != nil {
+			// closed while waiting, the rejections are final
+			for _, item := range rejected {
+				bp.fail(item)
+			}
+			return
+		}
+		batch = retries
+	}
+}
+
+// retryable reports whether the attempt-th bulk request of a batch, which
+// failed as a whole with err, must be sent again.
+func (bp *BulkProcessor) retryable(err error, attempt int) bool {
+	if bp.ctx.Err() != nil {
+		return false
+	}
+	if e, ok := asError(err); ok && e.Status > 0 {
+		return bp.cfg.Retry.retryable(false, &http.Response{StatusCode: e.Status}, nil, attempt)
+	}
+	return bp.cfg.Retry.retryable(false, nil, err, attempt)
+}
+
+// fail queues a failed item for OnFailure.
+func (bp *BulkProcessor) fail(item BulkItem) {
+	if bp.cfg.OnFailure == nil {
+		return
+	}
+	bp.mu.Lock()
+	bp.failures = append(bp.failures, item)
+	bp.reports++
+	bp.failed.Signal()
+	bp.mu.Unlock()
+}
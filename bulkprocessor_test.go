@@ -0,0 +1,287 @@
+package goose
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gotsunami/goose/goosetest"
+)
+
+func TestBulkProcessor(t *testing.T) {
+	s := goosetest.NewServer(goosetest.WithVersion("7.10.2"))
+	defer s.Close()
+	es, err := NewElasticSearch(s.IndexURL(index))
+	if err != nil {
+		t.Fatal("Cannot create client:", err)
+	}
+	var mu sync.Mutex
+	var failed []BulkItem
+	bp := es.NewBulkProcessor(BulkProcessorConfig{
+		Workers:    2,
+		MaxActions: 10,
+		OnFailure: func(item BulkItem) {
+			mu.Lock()
+			failed = append(failed, item)
+			mu.Unlock()
+		},
+	})
+	var wg sync.WaitGroup
+	for g := 0; g < 5; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				if err := bp.AddIndex(&DummyObject{Id: 5*g + i}); err != nil {
+					t.Errorf("Cannot add: %v", err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if err := bp.Flush(); err != nil {
+		t.Fatal("Cannot flush:", err)
+	}
+	if n := s.Count(index, ""); n != 25 {
+		t.Errorf("expected 25 documents, got %d", n)
+	}
+	s.AssertRequestCount(t, "POST", "/"+index+"/_bulk", 3)
+
+	bp.AddCreate(&DummyObject{Id: 1})
+	bp.AddDelete(&DummyObject{Id: 2})
+	bp.AddUpdate(&DummyObject{Id: 3}, NewUpdateBuilder().SetDoc(M{"len": 3}))
+	if err := bp.Close(); err != nil {
+		t.Fatal("Cannot close:", err)
+	}
+	if len(failed) != 1 || !IsConflict(failed[0].Err) || failed[0].Object.Key() != "1" {
+		t.Errorf("expected a conflict on 1, got %+v", failed)
+	}
+	if n := s.Count(index, ""); n != 24 {
+		t.Errorf("expected 24 documents, got %d", n)
+	}
+	if err := bp.AddIndex(&DummyObject{Id: 1}); err != ErrBulkProcessorClosed {
+		t.Errorf("expected an error once closed, got %v", err)
+	}
+	if err := bp.Close(); err != ErrBulkProcessorClosed {
+		t.Errorf("expected an error once closed, got %v", err)
+	}
+}
+
+func TestBulkProcessorFlushes(t *testing.T) {
+	s := goosetest.NewServer(goosetest.WithVersion("8.11.1"))
+	defer s.Close()
+	es, err := NewElasticSearch(s.IndexURL(index))
+	if err != nil {
+		t.Fatal("Cannot create client:", err)
+	}
+	// a request per action once the size is reached
+	bp := es.NewBulkProcessor(BulkProcessorConfig{MaxBytes: 150})
+	for i := 0; i < 3; i++ {
+		bp.AddIndex(&DummyObject{Id: i, Description: strings.Repeat("x", 100)})
+	}
+	bp.Close()
+	s.AssertRequestCount(t, "POST", "/"+index+"/_bulk", 3)
+
+	bp = es.NewBulkProcessor(BulkProcessorConfig{FlushInterval: 10 * time.Millisecond})
+	defer bp.Close()
+	bp.AddIndex(&DummyObject{Id: 42})
+	for deadline := time.Now().Add(time.Second); s.Count(index, "") != 4; {
+		if time.Now().After(deadline) {
+			t.Fatal("actions not flushed periodically")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// rejected actions are retried, failures of whole requests reported
+func TestBulkProcessorRetries(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		n := strings.Count(string(body), "\n") / 2
+		var items []string
+		for i := 0; i < n; i++ {
+			switch {
+			case atomic.AddInt32(&calls, 1) <= 2:
+				items = append(items, `{"index":{"_id":"x","status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}}`)
+			default:
+				items = append(items, `{"index":{"_id":"x","status":201,"result":"created"}}`)
+			}
+		}
+		fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithIndexCreation(false), WithVersion("7.10.2"))
+	policy := NewRetryPolicy(2)
+	policy.InitialBackoff = time.Millisecond
+	var failed []BulkItem
+	bp := es.NewBulkProcessor(BulkProcessorConfig{Retry: policy, OnFailure: func(item BulkItem) {
+		failed = append(failed, item)
+	}})
+	bp.AddIndex(&DummyObject{Id: 1})
+	bp.AddIndex(&DummyObject{Id: 2})
+	bp.AddIndex(&DummyObject{Id: 3})
+	bp.Close()
+	// 3 items, then 2 rejected ones retried once
+	if calls != 5 || len(failed) != 0 {
+		t.Errorf("expected 5 items sent without failures, got %d and %+v", calls, failed)
+	}
+
+	calls = 0
+	policy.MaxAttempts = 1
+	bp = es.NewBulkProcessor(BulkProcessorConfig{Retry: policy, OnFailure: func(item BulkItem) {
+		failed = append(failed, item)
+	}})
+	bp.AddIndex(&DummyObject{Id: 1})
+	bp.Close()
+	if len(failed) != 1 || failed[0].Status != http.StatusTooManyRequests {
+		t.Errorf("expected a rejected item, got %+v", failed)
+	}
+
+	ts.Close()
+	failed = nil
+	bp = es.NewBulkProcessor(BulkProcessorConfig{Retry: policy, OnFailure: func(item BulkItem) {
+		failed = append(failed, item)
+	}})
+	bp.AddDelete(&DummyObject{Id: 1})
+	bp.AddDelete(&DummyObject{Id: 2})
+	bp.Close()
+	if len(failed) != 2 || failed[0].Err == nil || failed[1].Id != "2" {
+		t.Errorf("expected 2 failed items, got %+v", failed)
+	}
+}
+
+// bulk requests failing as a whole are retried like other POST requests
+func TestBulkProcessorRequestRetries(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			fmt.Fprint(w, `{"took":1,"errors":false,"items":[{"index":{"_id":"1","status":201,"result":"created"}}]}`)
+		}
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithIndexCreation(false), WithVersion("7.10.2"))
+	policy := NewRetryPolicy(3)
+	policy.InitialBackoff = time.Millisecond
+	policy.RetryNonIdempotent = true
+	var failed []BulkItem
+	bp := es.NewBulkProcessor(BulkProcessorConfig{Retry: policy, OnFailure: func(item BulkItem) {
+		failed = append(failed, item)
+	}})
+	bp.AddIndex(&DummyObject{Id: 1})
+	bp.Close()
+	if calls != 3 || len(failed) != 0 {
+		t.Errorf("expected 3 requests without failures, got %d and %+v", calls, failed)
+	}
+
+	// a 503 error is ambiguous, the request may have been executed
+	calls = 0
+	policy.RetryNonIdempotent = false
+	bp = es.NewBulkProcessor(BulkProcessorConfig{Retry: policy, OnFailure: func(item BulkItem) {
+		failed = append(failed, item)
+	}})
+	bp.AddIndex(&DummyObject{Id: 1})
+	bp.Close()
+	if calls != 2 || len(failed) != 1 || failed[0].Err == nil {
+		t.Errorf("expected 2 requests and a failed item, got %d and %+v", calls, failed)
+	}
+}
+
+// cfg.Retry is the only retry policy of the requests of the processor
+func TestBulkProcessorInstanceRetries(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithIndexCreation(false), WithVersion("7.10.2"), WithRetryPolicy(newRetryPolicy()))
+	policy := newRetryPolicy()
+	policy.MaxAttempts = 2
+	bp := es.NewBulkProcessor(BulkProcessorConfig{Retry: policy})
+	bp.AddIndex(&DummyObject{Id: 1})
+	bp.Close()
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+}
+
+// OnFailure can add actions while every worker is busy
+func TestBulkProcessorOnFailureAdds(t *testing.T) {
+	s := goosetest.NewServer(goosetest.WithVersion("7.10.2"))
+	defer s.Close()
+	es, _ := NewElasticSearch(s.IndexURL(index))
+	if err := es.Insert(&DummyObject{Id: 1}); err != nil {
+		t.Fatal("Cannot insert:", err)
+	}
+	var bp *BulkProcessor
+	bp = es.NewBulkProcessor(BulkProcessorConfig{Workers: 1, MaxActions: 1, OnFailure: func(item BulkItem) {
+		if IsConflict(item.Err) {
+			bp.AddIndex(item.Object)
+		}
+	}})
+	closed := make(chan struct{})
+	go func() {
+		bp.AddCreate(&DummyObject{Id: 1, Description: "overwritten"})
+		bp.Flush()
+		bp.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("bulk processor deadlocked")
+	}
+	dummy := &DummyObject{Id: 1}
+	if _, err := es.Get(dummy); err != nil || dummy.Description != "overwritten" {
+		t.Errorf("action added by OnFailure not sent %+v (%v)", dummy, err)
+	}
+}
+
+func TestBulkProcessorCloseContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithIndexCreation(false), WithVersion("7.10.2"))
+	var failed int32
+	bp := es.NewBulkProcessor(BulkProcessorConfig{OnFailure: func(item BulkItem) {
+		atomic.AddInt32(&failed, 1)
+	}})
+	bp.AddIndex(&DummyObject{Id: 1})
+	bp.AddIndex(&DummyObject{Id: 2})
+
+	// the default policy would retry for more than a second
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := bp.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Close blocked for %v", d)
+	}
+	if failed != 2 {
+		t.Errorf("expected the 2 pending actions to fail, got %d", failed)
+	}
+	if err := bp.AddIndex(&DummyObject{Id: 3}); err != ErrBulkProcessorClosed {
+		t.Errorf("expected ErrBulkProcessorClosed, got %v", err)
+	}
+}
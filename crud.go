@@ -2,9 +2,11 @@ package goose
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"reflect"
 	"strings"
 )
@@ -44,8 +46,12 @@ func buildPath(object ElasticObject) (string, error) {
 			return path, errors.New(fmt.Sprintf("%s.BuildPath() returned invalid path.", t.String()))
 		}
 	} else {
+		// objects are usually pointers to named types
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
 		path = fmt.Sprintf("%s__%s/", strings.Replace(t.PkgPath(), "/", "_", -1), strings.ToLower(t.Name()))
-		if len(path) == 2 {
+		if t.Name() == "" {
 			return path, errors.New("Object cannot be an unnamed type.")
 		}
 	}
@@ -53,8 +59,18 @@ func buildPath(object ElasticObject) (string, error) {
 }
 
 // adds an element to the index. Caller must ensure that id is unique for each inserted object.
+// The write is conditioned on the version of Versioned objects.
 func (se *ElasticSearch) Insert(object ElasticObject) error {
-	path, err := buildPath(object)
+	return se.InsertContext(context.Background(), object)
+}
+
+// InsertContext is like Insert but uses ctx to bound the request.
+func (se *ElasticSearch) InsertContext(ctx context.Context, object ElasticObject) error {
+	d, err := se.dialect(ctx)
+	if err != nil {
+		return err
+	}
+	path, err := se.docPath(d, object)
 	if err != nil {
 		return err
 	}
@@ -64,75 +80,128 @@ func (se *ElasticSearch) Insert(object ElasticObject) error {
 	}
 	body := strings.NewReader(string(jsondata))
 
-	return se.sendRequest(PUT, se.serverUrl+se.basePath+path+object.Key(), body)
+	return se.sendWrite(ctx, "Insert", PUT, path, nil, "", object, body, nil)
 }
 
-// BulkInsert indexes several objects at once using the ES bulk API.
-func (se *ElasticSearch) BulkInsert(objects []ElasticObject) error {
-	if len(objects) == 0 {
-		return errors.New("no object to bulk insert")
+// Create adds an element to the index unless an element with the same key
+// exists, in which case it fails with a conflict error (see IsConflict).
+func (se *ElasticSearch) Create(object ElasticObject) error {
+	return se.CreateContext(context.Background(), object)
+}
+
+// CreateContext is like Create but uses ctx to bound the request.
+func (se *ElasticSearch) CreateContext(ctx context.Context, object ElasticObject) error {
+	d, err := se.dialect(ctx)
+	if err != nil {
+		return err
 	}
-	path, err := buildPath(objects[0])
+	path, err := se.docPath(d, object)
 	if err != nil {
 		return err
 	}
-	type index struct {
-		Id string `json:"_id"`
+	jsondata, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	params := url.Values{"op_type": {"create"}}
+	return se.sendWrite(ctx, "Create", PUT, path, params, "", object, bytes.NewReader(jsondata), nil)
+}
+
+// InsertWithGeneratedId adds an element to the index under an id generated
+// by ES, which is returned. object.Key() is not used: it suits objects whose
+// natural key is unknown.
+func (se *ElasticSearch) InsertWithGeneratedId(object ElasticObject) (string, error) {
+	return se.InsertWithGeneratedIdContext(context.Background(), object)
+}
+
+// InsertWithGeneratedIdContext is like InsertWithGeneratedId but uses ctx to
+// bound the request.
+func (se *ElasticSearch) InsertWithGeneratedIdContext(ctx context.Context, object ElasticObject) (string, error) {
+	d, err := se.dialect(ctx)
+	if err != nil {
+		return "", err
+	}
+	path, err := se.typePath(d, object)
+	if err != nil {
+		return "", err
 	}
-	// Index action
-	type action struct {
-		Index index `json:"index"`
+	if d >= DIALECT_8X {
+		path += "_doc"
 	}
-	var buf bytes.Buffer
-	for _, object := range objects {
-		// Index action then source on next line
-		for _, d := range []interface{}{&action{index{object.Key()}}, object} {
-			jsondata, err := json.Marshal(d)
-			if err != nil {
-				return err
-			}
-			_, err = buf.Write(jsondata)
-			if err != nil {
-				return err
-			}
-			buf.Write([]byte("\n")) // Required
-		}
+	jsondata, err := json.Marshal(object)
+	if err != nil {
+		return "", err
 	}
-	return se.sendRequest(POST, se.serverUrl+se.basePath+path+actionBulk, &buf)
+	res := new(result)
+	// the document is new: the write is not conditioned on a version
+	params := url.Values{"op_type": {"create"}}
+	if err = se.sendWrite(ctx, "InsertWithGeneratedId", POST, path, params, "", object, bytes.NewReader(jsondata), res); err != nil {
+		return "", err
+	}
+	return res.Id, nil
 }
 
-// updates an element in the index. TODO: check _update
-func (se *ElasticSearch) Update(object ElasticObject) error {
-	path, err := buildPath(object)
-	if err != nil {
-		return err
+// BulkInsert indexes several objects at once using the ES bulk API.
+func (se *ElasticSearch) BulkInsert(objects []ElasticObject) error {
+	return se.BulkInsertContext(context.Background(), objects)
+}
+
+// BulkInsertContext is like BulkInsert but uses ctx to bound the request.
+func (se *ElasticSearch) BulkInsertContext(ctx context.Context, objects []ElasticObject) error {
+	if len(objects) == 0 {
+		return errors.New("no object to bulk insert")
 	}
-	jsondata, err := json.Marshal(Doc{Doc: object})
+	bb := NewBulkBuilder()
+	for _, object := range objects {
+		bb.AddIndex(object)
+	}
+	res, err := se.bulk(ctx, "BulkInsert", bb, se.retry)
 	if err != nil {
 		return err
 	}
-	body := strings.NewReader(string(jsondata))
+	return res.Err()
+}
+
+// updates an element in the index. TODO: check _update
+// The write is conditioned on the version of Versioned objects.
+func (se *ElasticSearch) Update(object ElasticObject) error {
+	return se.UpdateContext(context.Background(), object)
+}
 
-	return se.sendRequest(POST, se.serverUrl+se.basePath+path+strictSlash(object.Key())+actionUpdate, body)
+// UpdateContext is like Update but uses ctx to bound the request.
+func (se *ElasticSearch) UpdateContext(ctx context.Context, object ElasticObject) error {
+	return se.UpdateWithContext(ctx, object, nil)
 }
 
 // adds an element to the index. Caller must ensure that id is unique for each inserted object.
 func (se *ElasticSearch) Get(object ElasticObject) (bool, error) {
-	path, err := buildPath(object)
+	return se.GetContext(context.Background(), object)
+}
+
+// GetContext is like Get but uses ctx to bound the request.
+func (se *ElasticSearch) GetContext(ctx context.Context, object ElasticObject) (bool, error) {
+	v, err := se.VersionContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	path, err := se.docPath(v.Dialect(), object)
 	if err != nil {
 		return false, err
 	}
+	params := make(url.Values)
+	routingParams(v, object, params)
+	path = addQuery(path, params.Encode())
 	jsondata, err := json.Marshal(object)
 	if err != nil {
 		return false, err
 	}
 	body := strings.NewReader(string(jsondata))
 
-	resp, err := se.sendRequestAndGetResponse(GET, se.serverUrl+se.basePath+path+object.Key(), body)
-	defer resp.Body.Close()
+	resp, err := se.sendRequestAndGetResponse(ctx, "Get", GET, path, body)
 	if err != nil {
 		return false, err
 	}
+	defer resp.Body.Close()
 
 	dec := json.NewDecoder(resp.Body)
 	var res = new(result)
@@ -141,6 +210,9 @@ func (se *ElasticSearch) Get(object ElasticObject) (bool, error) {
 	}
 
 	if res.Found {
+		if vo, ok := object.(Versioned); ok {
+			vo.SetDocVersion(res.docVersion())
+		}
 		bj, _ := json.Marshal(res.Src)
 
 		err = json.Unmarshal(bj, object)
@@ -149,19 +221,32 @@ func (se *ElasticSearch) Get(object ElasticObject) (bool, error) {
 	return false, nil
 }
 
-// deletes an element from the index
+// deletes an element from the index. The deletion is conditioned on the
+// version of Versioned objects.
 func (se *ElasticSearch) Delete(object ElasticObject) error {
-	path, err := buildPath(object)
+	return se.DeleteContext(context.Background(), object)
+}
+
+// DeleteContext is like Delete but uses ctx to bound the request.
+func (se *ElasticSearch) DeleteContext(ctx context.Context, object ElasticObject) error {
+	d, err := se.dialect(ctx)
 	if err != nil {
 		return err
 	}
-	return se.sendRequest(DELETE, se.serverUrl+se.basePath+path+object.Key(), nil)
+	path, err := se.docPath(d, object)
+	if err != nil {
+		return err
+	}
+	return se.sendWrite(ctx, "Delete", DELETE, path, nil, "", object, nil, nil)
 }
 
 // deletes objects with a `query`
 // http://www.elasticsearch.org/guide/en/elasticsearch/reference/current/docs-delete-by-query.html
+//
+// ES 5.x and later only report the number of deleted documents.
 type DeletedIndex struct {
-	Shards struct {
+	Deleted int `json:"deleted"`
+	Shards  struct {
 		Total      int `json:"total"`
 		Successful int `json:"successful"`
 		Failed     int `json:"failed"`
@@ -172,33 +257,65 @@ type deleteResponse struct {
 }
 
 func (se *ElasticSearch) DeleteByQuery(object ElasticObject, q *QueryBuilder) (*DeletedIndex, error) {
+	return se.DeleteByQueryContext(context.Background(), object, q)
+}
+
+// DeleteByQueryContext is like DeleteByQuery but uses ctx to bound the
+// request.
+func (se *ElasticSearch) DeleteByQueryContext(ctx context.Context, object ElasticObject, q *QueryBuilder) (*DeletedIndex, error) {
 	if q == nil {
 		return nil, errors.New("Query is not valid")
 	}
-	path, err := buildPath(object)
+	ver, err := se.VersionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d := ver.Dialect()
+	path, err := se.typePath(d, object)
 	if err != nil {
 		return nil, err
 	}
 	// delete query does not accept from and size, so set them to 0 for `omitempty` to be triggered
 	q.Size = 0
-	data, err := q.ToJSON()
+	data, err := q.ToJSONDialect(d)
 	if err != nil {
 		return nil, err
 	}
 	body := strings.NewReader(data)
-	resp, err := se.sendRequestAndGetResponse(DELETE, se.serverUrl+se.basePath+path+actionQuery, body)
-	defer resp.Body.Close()
+	if d >= DIALECT_5X {
+		// _delete_by_query does not support wait_for
+		params := make(url.Values)
+		se.refreshParams(ver, "", params, false)
+		path = addQuery(addQuery(path+actionDeleteByQuery, searchRouting(object)), params.Encode())
+		resp, err := se.sendRequestAndGetResponse(ctx, "DeleteByQuery", POST, path, body)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		index := new(DeletedIndex)
+		return index, json.NewDecoder(resp.Body).Decode(index)
+	}
+	resp, err := se.sendRequestAndGetResponse(ctx, "DeleteByQuery", DELETE, addQuery(path+actionQuery, searchRouting(object)), body)
 	if err != nil {
 		return nil, err
 	}
-	if resp == nil {
-		return nil, errors.New("No response from ES server")
+	defer resp.Body.Close()
+	// the _query API has no refresh parameter
+	if se.refreshPolicy("") != REFRESH_NONE {
+		if err = se.RefreshContext(ctx); err != nil {
+			return nil, err
+		}
 	}
 	dec := json.NewDecoder(resp.Body)
 
 	dresp := new(deleteResponse)
-	err = dec.Decode(dresp)
+	if err = dec.Decode(dresp); err != nil {
+		return nil, err
+	}
 	v := reflect.ValueOf(dresp.Indices)
+	if v.Kind() != reflect.Map {
+		return nil, nil
+	}
 	keys := v.MapKeys()
 
 	for _, key := range keys {
@@ -5,7 +5,8 @@ import (
 
 	"reflect"
 	"testing"
-	"time"
+
+	"github.com/gotsunami/goose/goosetest"
 )
 
 type tT struct {
@@ -32,12 +33,19 @@ func TestBuildPath(t *testing.T) {
 	if path != testPath {
 		t.Errorf("path should be '%s', it's '%s'\n", testPath, path)
 	}
+
+	// path reflected from the type pointed to
+	testPath = "github.com_gotsunami_goose__dummyobject/"
+	if path, _ = buildPath(&DummyObject{}); path != testPath {
+		t.Errorf("path should be '%s', it's '%s'\n", testPath, path)
+	}
 }
 
 // consts and types are all defined in es_test.go
 func TestCrudOperations(t *testing.T) {
 	u, _ := url.Parse(uri + index)
-	es, _ := NewElasticSearch(u)
+	// writes are searchable at once, for the delete by query
+	es, _ := NewElasticSearch(u, WithRefresh(REFRESH_WAIT_FOR))
 	defer es.DeleteIndex()
 
 	dummy := DummyObject{
@@ -83,10 +91,9 @@ func TestCrudOperations(t *testing.T) {
 	if found == true {
 		t.Error("Found deleted object:", bogus)
 	}
-	time.Sleep(1 * time.Second)
 
 	u, _ = url.Parse(uri + index2)
-	es2, _ := NewElasticSearch(u)
+	es2, _ := NewElasticSearch(u, WithRefresh(REFRESH_WAIT_FOR))
 
 	if err := es.Insert(&dummy); err != nil {
 		t.Error("Cannot insert dummy object: %v", err)
@@ -118,7 +125,48 @@ func TestCrudOperations(t *testing.T) {
 	if found == false {
 		t.Error("Cannot find object (should not have been deleted by query):", bogus)
 	}
-	time.Sleep(1 * time.Second)
 
 	TestCleanIndex(t)
 }
+
+// testCreate checks create-only inserts and generated ids with engine.
+func testCreate(t *testing.T, engine SearchEngine) {
+	dummy := &DummyObject{Id: 1, Description: "created"}
+	if err := engine.Create(dummy); err != nil {
+		t.Fatalf("Cannot create: %v", err)
+	}
+	if err := engine.Create(&DummyObject{Id: 1, Description: "overwritten"}); !IsConflict(err) {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+	got := &DummyObject{Id: 1}
+	if _, err := engine.Get(got); err != nil || got.Description != "created" {
+		t.Errorf("existing document overwritten %+v (%v)", got, err)
+	}
+
+	v := &versionedObject{Id: 2, Title: "generated"}
+	id, err := engine.InsertWithGeneratedId(v)
+	if err != nil || id == "" || id == v.Key() {
+		t.Fatalf("wrong generated id %q (%v)", id, err)
+	}
+	if v.DocVersion().Version != 1 {
+		t.Errorf("wrong version of the created document %+v", v.DocVersion())
+	}
+	other, err := engine.InsertWithGeneratedId(v)
+	if err != nil || other == id {
+		t.Errorf("wrong generated id %q (%v)", other, err)
+	}
+	if n, _ := engine.Count(v); n != 2 {
+		t.Errorf("expected 2 documents, got %d", n)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	forEachEngine(t, []string{"1.7.5", "7.10.2", "8.11.1", memoryEngine}, func(t *testing.T, s *goosetest.Server, engine SearchEngine) {
+		testCreate(t, engine)
+		if es, ok := engine.(*ElasticSearch); ok {
+			if v, _ := es.Version(); v.Dialect() == DIALECT_8X {
+				s.AssertRequested(t, "POST", "/"+index+"-versionedobject/_doc?op_type=create")
+			}
+		}
+	}, perTypeIndex)
+}
@@ -0,0 +1,139 @@
+package goose
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Error is an error replied by ES. It can be retrieved from the errors
+// returned by goose with errors.As:
+//
+//	var esErr *goose.Error
+//	if errors.As(err, &esErr) && esErr.Type == "mapper_parsing_exception" {
+//	    ...
+//	}
+type Error struct {
+	Status    int           // HTTP status code
+	Type      string        // ES error type, i.e index_not_found_exception
+	Reason    string        // Human readable explanation
+	Index     string        // Index concerned by the error, if any
+	RootCause []*ErrorCause // Underlying causes (ES 2.x and later)
+	Body      string        // Raw response body
+}
+
+// ErrorCause is one of the root causes of an Error.
+type ErrorCause struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+	Index  string `json:"index"`
+}
+
+func (e *Error) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("HTTP code %d, ES error: %s", e.Status, e.Body)
+	}
+	return fmt.Sprintf("HTTP code %d, ES error: %s: %s", e.Status, e.Type, e.Reason)
+}
+
+// parseError builds an Error from the status code and the body of an ES
+// response. ES 1.x replies with a string like
+//
+//	{"error":"IndexMissingException[[myindex] missing]","status":404}
+//
+// while later versions reply with an object
+//
+//	{"error":{"root_cause":[...],"type":"index_not_found_exception","reason":"no such index","index":"myindex"},"status":404}
+//
+// Bodies without any error (i.e a document not found) are accepted too.
+func parseError(status int, body []byte) *Error {
+	e := &Error{Status: status, Body: string(body)}
+	var reply struct {
+		Error json.RawMessage `json:"error"`
+		Index string          `json:"_index"`
+	}
+	if json.Unmarshal(body, &reply) != nil {
+		return e
+	}
+	e.Index = reply.Index
+
+	var legacy string
+	if json.Unmarshal(reply.Error, &legacy) == nil {
+		e.Reason = legacy
+		if i := strings.Index(legacy, "["); i > 0 && strings.HasSuffix(legacy, "]") {
+			e.Type, e.Reason = legacy[:i], legacy[i+1:len(legacy)-1]
+			// the reason of most exceptions starts with the index
+			if strings.HasPrefix(e.Reason, "[") {
+				if j := strings.Index(e.Reason, "]"); j > 0 {
+					e.Index = e.Reason[1:j]
+				}
+			}
+		}
+		return e
+	}
+
+	var cause struct {
+		ErrorCause
+		RootCause []*ErrorCause `json:"root_cause"`
+	}
+	if json.Unmarshal(reply.Error, &cause) == nil {
+		e.Type, e.Reason, e.RootCause = cause.Type, cause.Reason, cause.RootCause
+		if cause.Index != "" {
+			e.Index = cause.Index
+		}
+	}
+	return e
+}
+
+// hasType reports whether the error or one of its root causes has one of the
+// given types.
+func (e *Error) hasType(types ...string) bool {
+	for _, t := range types {
+		if e.Type == t {
+			return true
+		}
+		for _, c := range e.RootCause {
+			if c.Type == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func asError(err error) (*Error, bool) {
+	var e *Error
+	ok := errors.As(err, &e)
+	return e, ok
+}
+
+// IsNotFound returns true if err is an ES error telling that the document or
+// the index does not exist.
+func IsNotFound(err error) bool {
+	e, ok := asError(err)
+	return ok && e.Status == 404
+}
+
+// IsConflict returns true if err is an ES version conflict error.
+func IsConflict(err error) bool {
+	e, ok := asError(err)
+	return ok && e.Status == 409
+}
+
+// IsIndexMissing returns true if err is an ES error telling that the index
+// does not exist.
+func IsIndexMissing(err error) bool {
+	e, ok := asError(err)
+	return ok && e.hasType("index_not_found_exception", "IndexMissingException")
+}
+
+// IsMappingError returns true if err is an ES error about a mapping, i.e a
+// document which does not match the mapping of its type or an invalid mapping
+// definition.
+func IsMappingError(err error) bool {
+	e, ok := asError(err)
+	return ok && e.hasType("mapper_parsing_exception", "MapperParsingException",
+		"strict_dynamic_mapping_exception", "StrictDynamicMappingException",
+		"merge_mapping_exception", "MergeMappingException")
+}
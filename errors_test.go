@@ -0,0 +1,70 @@
+package goose
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		typ    string
+		reason string
+		index  string
+	}{
+		{404, `{"error":"IndexMissingException[[gooseindex] missing]","status":404}`,
+			"IndexMissingException", "[gooseindex] missing", "gooseindex"},
+		{409, `{"error":"VersionConflictEngineException[[gooseindex][2] [goose__dummyobject][1]: version conflict, current [2], provided [1]]","status":409}`,
+			"VersionConflictEngineException", "[gooseindex][2] [goose__dummyobject][1]: version conflict, current [2], provided [1]", "gooseindex"},
+		{404, `{"error":{"root_cause":[{"type":"index_not_found_exception","reason":"no such index","index":"gooseindex"}],"type":"index_not_found_exception","reason":"no such index","index":"gooseindex"},"status":404}`,
+			"index_not_found_exception", "no such index", "gooseindex"},
+		{404, `{"_index":"gooseindex","_type":"goose__dummyobject","_id":"1","found":false}`,
+			"", "", "gooseindex"},
+		{500, `not json`, "", "", ""},
+	}
+	for _, test := range tests {
+		e := parseError(test.status, []byte(test.body))
+		if e.Status != test.status || e.Type != test.typ || e.Reason != test.reason || e.Index != test.index {
+			t.Errorf("wrong error for %s. Expected %d/%q/%q/%q, got %d/%q/%q/%q", test.body,
+				test.status, test.typ, test.reason, test.index, e.Status, e.Type, e.Reason, e.Index)
+		}
+	}
+
+	e := parseError(400, []byte(`{"error":{"root_cause":[{"type":"mapper_parsing_exception","reason":"failed to parse"}],"type":"illegal_argument_exception","reason":"bad"},"status":400}`))
+	if len(e.RootCause) != 1 || !IsMappingError(e) {
+		t.Errorf("root cause not parsed: %v", e.RootCause)
+	}
+}
+
+func TestErrorHelpers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"type":"index_not_found_exception","reason":"no such index"},"status":404}`)
+		default:
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"error":{"type":"version_conflict_engine_exception","reason":"version conflict"},"status":409}`)
+		}
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithVersion(testVersion), WithIndexCreation(false))
+
+	_, err := es.Get(&DummyObject{Id: 1})
+	if !IsNotFound(err) || !IsIndexMissing(err) || IsConflict(err) {
+		t.Error("expected an index missing error, got", err)
+	}
+	err = es.Insert(&DummyObject{Id: 1})
+	if !IsConflict(fmt.Errorf("wrapped: %w", err)) || IsNotFound(err) || IsIndexMissing(err) {
+		t.Error("expected a conflict error, got", err)
+	}
+	if IsNotFound(nil) || IsConflict(fmt.Errorf("plain error")) {
+		t.Error("helpers match errors not returned by ES")
+	}
+}
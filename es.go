@@ -8,13 +8,20 @@ This package is based on docs for ES branch: 1.x
 package goose
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -22,9 +29,11 @@ const (
 	actionOpen     = "_open"
 	actionClose    = "_close"
 	actionStats    = "_stats"
+	actionRefresh  = "_refresh"
 	actionSettings = "_settings"
 	actionCount    = "_count"
 	actionBulk     = "_bulk"
+	actionMget     = "_mget"
 
 	// model actions
 	actionMappings = "_mappings"
@@ -36,8 +45,14 @@ const (
 	typeScan       = "?search_type=scan&scroll=10m&size=10"
 	typeSearch     = "" // Basic search
 
+	// model actions of ES 5.x and later
+	actionDeleteByQuery = "_delete_by_query"
+	typeSize0           = "?size=0" // replaces typeCount
+
 	envelopeShape  = "envelope"
 	withinRelation = "within"
+
+	defaultMaxIdleConnsPerHost = 32
 )
 
 const (
@@ -91,55 +106,159 @@ func strictSlash(in string) string {
 }
 
 type result struct {
-	Index   string      `json:"_index"`
-	Type    string      `json:"_type"`
-	Id      string      `json:"_id"`
-	Version int         `json:"_version"`
-	Found   bool        `json:"found"`
-	Src     interface{} `json:"_source"`
+	Index       string      `json:"_index"`
+	Type        string      `json:"_type"`
+	Id          string      `json:"_id"`
+	Version     int         `json:"_version"`
+	SeqNo       *int64      `json:"_seq_no"`       // ES 6.x and later
+	PrimaryTerm *int64      `json:"_primary_term"` // ES 6.x and later
+	Found       bool        `json:"found"`
+	Src         interface{} `json:"_source"`
 }
 
-type resultFacet struct {
+// ResultFacet is a terms facet, or a terms aggregation, of a search.
+type ResultFacet struct {
 	Total int `json:"total"`
 	Terms []M `json:"terms"`
 }
 
-// Ad-hoc structs for easy unmarshaling search engine's results
-type resultSet struct {
+// ResultHit is a search hit. Object is the hit decoded as the searched type.
+type ResultHit struct {
+	Id     string                 `json:"_id"`
+	Src    map[string]interface{} `json:"_source"`
+	Object interface{}
+}
+
+// ResultSet is the result of a search: its hits, their total number and the
+// terms facets.
+type ResultSet struct {
 	Took int
 	Hits struct {
 		Total int
-		Data  []struct {
-			Id     string                 `json:"_id"`
-			Src    map[string]interface{} `json:"_source"`
-			Object interface{}
+		Data  []ResultHit `json:"hits"`
+	}
+	Facets map[string]ResultFacet `json:"facets"`
+}
+
+// UnmarshalJSON decodes the results of all ES versions: the total number of
+// hits is an object since ES 7.x and aggregations replace facets since
+// ES 2.x. Terms aggregations are decoded as terms facets.
+func (rs *ResultSet) UnmarshalJSON(b []byte) error {
+	type plain ResultSet // without UnmarshalJSON
+	var raw struct {
+		plain
+		Hits struct {
+			Total json.RawMessage `json:"total"`
+			Data  json.RawMessage `json:"hits"`
 		} `json:"hits"`
+		Aggregations map[string]struct {
+			Buckets []struct {
+				Key      interface{} `json:"key"`
+				DocCount int         `json:"doc_count"`
+			} `json:"buckets"`
+			SumOtherDocCount int `json:"sum_other_doc_count"`
+		} `json:"aggregations"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*rs = ResultSet(raw.plain)
+	if len(raw.Hits.Total) > 0 {
+		var total struct {
+			Value int `json:"value"`
+		}
+		if err := json.Unmarshal(raw.Hits.Total, &rs.Hits.Total); err != nil {
+			if err = json.Unmarshal(raw.Hits.Total, &total); err != nil {
+				return err
+			}
+			rs.Hits.Total = total.Value
+		}
+	}
+	if len(raw.Hits.Data) > 0 {
+		if err := json.Unmarshal(raw.Hits.Data, &rs.Hits.Data); err != nil {
+			return err
+		}
+	}
+	for name, agg := range raw.Aggregations {
+		if rs.Facets == nil {
+			rs.Facets = make(map[string]ResultFacet, len(raw.Aggregations))
+		}
+		facet := ResultFacet{Total: agg.SumOtherDocCount, Terms: make([]M, len(agg.Buckets))}
+		for i, bucket := range agg.Buckets {
+			facet.Terms[i] = M{"term": bucket.Key, "count": bucket.DocCount}
+			facet.Total += bucket.DocCount
+		}
+		rs.Facets[name] = facet
 	}
-	Facets map[string]resultFacet `json:"facets"`
+	return nil
 }
 
 type scanResultSet struct {
-	resultSet
+	ResultSet
 	ScrollId string `json:"_scroll_id"` // query id
 }
 
+// UnmarshalJSON prevents the promoted ResultSet.UnmarshalJSON from ignoring
+// the scroll id.
+func (srs *scanResultSet) UnmarshalJSON(b []byte) error {
+	var scroll struct {
+		ScrollId string `json:"_scroll_id"`
+	}
+	if err := json.Unmarshal(b, &scroll); err != nil {
+		return err
+	}
+	srs.ScrollId = scroll.ScrollId
+	return srs.ResultSet.UnmarshalJSON(b)
+}
+
 type ScrollId string
 
 // SearchEngine defines the interface for CRUD operations of our
-// central search engine (CSE).
+// central search engine (CSE). ElasticSearch implements it with an ES cluster
+// and MemoryEngine in memory.
 type SearchEngine interface {
-	// Item
-	// InsertItem(*db.Item, *db.Account) error
-	// DeleteItem(*db.Item, *db.Account) error
-	// DeleteItemId(string) error
-	// BuildItemResultRows(rset *resultSet) ([]*ResultRow, error)
-	// CountItems(*QuerySet) (*ItemCount, error)
-
-	// PrepareScanSearch(string, *QuerySet) (ScrollId, int, error)
-	// PrepareSearch(string, *QuerySet, ScrollId) (ScrollId, int, error)
-	// ScrollSearch(ScrollId) (*resultSet, error)
+	Insert(object ElasticObject) error
+	InsertContext(ctx context.Context, object ElasticObject) error
+	Create(object ElasticObject) error
+	CreateContext(ctx context.Context, object ElasticObject) error
+	InsertWithGeneratedId(object ElasticObject) (string, error)
+	InsertWithGeneratedIdContext(ctx context.Context, object ElasticObject) (string, error)
+	BulkInsert(objects []ElasticObject) error
+	BulkInsertContext(ctx context.Context, objects []ElasticObject) error
+	Bulk(bb *BulkBuilder) (*BulkResponse, error)
+	BulkContext(ctx context.Context, bb *BulkBuilder) (*BulkResponse, error)
+	Update(object ElasticObject) error
+	UpdateContext(ctx context.Context, object ElasticObject) error
+	UpdateWith(object ElasticObject, ub *UpdateBuilder) error
+	UpdateWithContext(ctx context.Context, object ElasticObject, ub *UpdateBuilder) error
+	Get(object ElasticObject) (bool, error)
+	GetContext(ctx context.Context, object ElasticObject) (bool, error)
+	MultiGet(objects []ElasticObject) ([]MultiGetResult, error)
+	MultiGetContext(ctx context.Context, objects []ElasticObject) ([]MultiGetResult, error)
+	Delete(object ElasticObject) error
+	DeleteContext(ctx context.Context, object ElasticObject) error
+	DeleteByQuery(object ElasticObject, q *QueryBuilder) (*DeletedIndex, error)
+	DeleteByQueryContext(ctx context.Context, object ElasticObject, q *QueryBuilder) (*DeletedIndex, error)
+	Modify(object Versioned, mutate func(ElasticObject) error) error
+	ModifyContext(ctx context.Context, object Versioned, mutate func(ElasticObject) error) error
+	Refresh() error
+	RefreshContext(ctx context.Context) error
+
+	Count(object ElasticObject) (int, error)
+	CountContext(ctx context.Context, object ElasticObject) (int, error)
+	Search(object ElasticObject, qb *QueryBuilder) (*ResultSet, error)
+	SearchContext(ctx context.Context, object ElasticObject, qb *QueryBuilder) (*ResultSet, error)
+	SearchCount(object ElasticObject, qb *QueryBuilder) (*ResultSet, error)
+	SearchCountContext(ctx context.Context, object ElasticObject, qb *QueryBuilder) (*ResultSet, error)
+	SearchRawJSON(object ElasticObject, jsondata string) (*ResultSet, error)
+	SearchRawJSONContext(ctx context.Context, object ElasticObject, jsondata string) (*ResultSet, error)
 }
 
+var (
+	_ SearchEngine = (*ElasticSearch)(nil)
+	_ SearchEngine = (*MemoryEngine)(nil)
+)
+
 type HttpMethod string
 
 // Global search engine instance.
@@ -147,35 +266,118 @@ var engine *ElasticSearch
 
 // Search engine implementation for elasticsearch.
 type ElasticSearch struct {
-	serverUrl string
-	basePath  string
-	lock      chan bool
-	stype     string
+	serverUrl      string
+	scheme         string
+	basePath       string
+	inflight       chan struct{} // nil if the number of requests is not limited
+	maxInFlight    int
+	client         *http.Client
+	transport      http.RoundTripper
+	header         http.Header
+	timeout        time.Duration
+	createIndex    bool
+	seeds          []string
+	nodes          *nodePool
+	sniffInterval  time.Duration
+	lastSniff      atomic.Int64 // UnixNano
+	retry          *RetryPolicy
+	breaker        *CircuitBreaker
+	modifyAttempts int
+	refresh        RefreshPolicy // default refresh policy of writes
+	tlsConfig      *tls.Config
+	tracer         Tracer
+	metrics        Metrics
+	versionMu      sync.Mutex
+	version        *Version     // nil until detected
+	versionCall    *versionCall // detection in flight, if any
+
+	indexResolver func(typ string) string // index of each type on typeless dialects
+	indexMu       sync.Mutex
+	indexType     string          // type stored in the base index on typeless dialects
+	typeIndices   map[string]bool // indices given by indexResolver so far
 }
 
 // NewElasticSearch creates a new ElasticSearch instance which is also
 // assigned to the Engine variable. The uri parameter is used to access
 // the ElasticSearch web service, i.e http://localhost:9200/<index>
-// default search mode is typeSearch
-func NewElasticSearch(uri *url.URL) (*ElasticSearch, error) {
+//
+// An instance is safe for concurrent use by multiple goroutines and should be
+// shared: it keeps the connections to ES open for reuse.
+//
+// Unless WithIndexCreation(false) is given, the index is created if it does
+// not exist yet.
+//
+// The version of the cluster is detected along with the index creation, or
+// with the first request depending on it if WithIndexCreation(false) is given,
+// so that requests are made in the dialect of the cluster. WithVersion skips
+// the detection. Since ES 8.x, an index stores a single type of objects: see
+// WithIndexResolver to store several ones.
+//
+// Credentials given in uri are used for HTTP basic authentication.
+//
+// Requests are balanced across the host of uri and the nodes given with
+// WithNodes. A node which cannot be reached is put aside for a while and the
+// request is sent to the next node. Nodes timing out, resetting connections
+// or replying 502, 503 or 504 to consecutive requests are put aside too.
+// Transient failures are retried according to the policy given with
+// WithRetryPolicy, if any.
+func NewElasticSearch(uri *url.URL, options ...Option) (*ElasticSearch, error) {
+	return NewElasticSearchContext(context.Background(), uri, options...)
+}
+
+// NewElasticSearchContext is like NewElasticSearch but uses ctx to bound
+// the index creation request.
+func NewElasticSearchContext(ctx context.Context, uri *url.URL, options ...Option) (*ElasticSearch, error) {
 	if uri == nil {
 		return nil, errors.New("nil ES path")
 	}
 
+	header := make(http.Header)
+	if uri.User != nil {
+		password, _ := uri.User.Password()
+		header.Set("Authorization", "Basic "+basicAuth(uri.User.Username(), password))
+	}
+
 	// Always set global variable
 	engine := &ElasticSearch{
-		serverUrl: uri.Scheme + "://" + uri.Host,
-		basePath:  strictSlash(uri.Path),
-		lock:      make(chan bool, 1),
-		stype:     typeSearch,
+		serverUrl:   uri.Scheme + "://" + uri.Host,
+		scheme:      uri.Scheme,
+		basePath:    strictSlash(uri.Path),
+		header:      header,
+		createIndex: true,
+		nodes:       newNodePool(nil),
+	}
+	for _, option := range options {
+		if err := option(engine); err != nil {
+			return nil, err
+		}
+	}
+	if err := engine.setupClient(); err != nil {
+		return nil, err
+	}
+	if engine.maxInFlight > 0 {
+		engine.inflight = make(chan struct{}, engine.maxInFlight)
 	}
-	return engine, engine.CreateIndexIfNeeded()
+	engine.nodes.set(append([]string{engine.serverUrl}, engine.seeds...))
+	if engine.sniffInterval > 0 {
+		if err := engine.SniffContext(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if !engine.createIndex {
+		return engine, nil
+	}
+	if _, err := engine.VersionContext(ctx); err != nil {
+		return engine, err
+	}
+	return engine, engine.CreateIndexIfNeededContext(ctx)
 }
 
+// returns an *Error if ES replied with an error status
 func (se *ElasticSearch) handleResponse(r *http.Response) error {
 	if r.StatusCode != http.StatusOK && r.StatusCode != http.StatusCreated {
 		d, _ := ioutil.ReadAll(r.Body)
-		return fmt.Errorf("HTTP code %d, ES error: %s", r.StatusCode, string(d))
+		return parseError(r.StatusCode, d)
 	}
 	return nil
 }
@@ -186,28 +388,257 @@ func (se *ElasticSearch) ServerUrl() string {
 
 type callback func(*http.Response) error
 
+// dispatch sends a request to the next alive node, failing over to the
+// following nodes as long as nodes cannot be dialed. The request holds one
+// of the WithMaxInFlight slots until release is called, once its response
+// body is closed. The slot is already released if err is not nil.
+func (se *ElasticSearch) dispatch(ctx context.Context, op string, m HttpMethod, path string, body []byte, attempt int) (resp *http.Response, release func(), err error) {
+	release = func() {}
+	if se.inflight != nil {
+		select {
+		case se.inflight <- struct{}{}:
+		case <-ctx.Done():
+			return nil, release, ctx.Err()
+		}
+		var once sync.Once
+		release = func() { once.Do(func() { <-se.inflight }) }
+	}
+	cb := se.breaker
+	if cb != nil && cb.Scope == BREAKER_PER_OPERATION {
+		var generation int
+		if generation, err = cb.allow(op); err == nil {
+			resp, err = se.failover(ctx, nil, m, path, body, attempt)
+			cb.done(op, generation, resp, err)
+		}
+	} else {
+		resp, err = se.failover(ctx, cb, m, path, body, attempt)
+	}
+	if err != nil {
+		release()
+	}
+	return resp, release, err
+}
+
+// failover sends the request to the next alive node, and to the following
+// ones if it cannot be dialed. Nodes are also marked dead when they time
+// out, reset the connection or keep replying 502, 503 or 504, so that the
+// next requests and retries go to other nodes. With a per node circuit
+// breaker cb, nodes whose circuit is open are skipped.
+func (se *ElasticSearch) failover(ctx context.Context, cb *CircuitBreaker, m HttpMethod, path string, body []byte, attempt int) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	for tries := se.nodes.len(); tries > 0; tries-- {
+		n := se.nodes.next()
+		generation := 0
+		if cb != nil {
+			var cerr error
+			if generation, cerr = cb.allow(n.url); cerr != nil {
+				if err == nil {
+					err = cerr
+				}
+				continue
+			}
+		}
+		resp, err = se.roundTrip(ctx, n.url+path, m, body, attempt)
+		if cb != nil {
+			cb.done(n.url, generation, resp, err)
+		}
+		if err == nil {
+			se.nodes.markReply(n, resp.StatusCode)
+			break
+		}
+		if isDialError(err) && ctx.Err() == nil {
+			se.nodes.markDead(n)
+			continue
+		}
+		// the request may have been executed: it is not sent to another node
+		if isNodeFailure(ctx, err) {
+			se.nodes.markDead(n)
+		}
+		break
+	}
+	return resp, err
+}
+
+// setupClient builds the HTTP client of the instance from the client, the
+// transport and the TLS configuration given as options.
+func (se *ElasticSearch) setupClient() error {
+	client := &http.Client{}
+	if se.client != nil {
+		*client = *se.client
+	} else if se.transport == nil {
+		client.Transport = newTransport(se.maxInFlight)
+	}
+	if se.transport != nil {
+		client.Transport = se.transport
+	}
+	if se.tlsConfig != nil {
+		rt := client.Transport
+		if rt == nil {
+			rt = http.DefaultTransport
+		}
+		t, ok := rt.(*http.Transport)
+		if !ok {
+			return errors.New("TLS options require an *http.Transport")
+		}
+		t = t.Clone()
+		t.TLSClientConfig = se.tlsConfig
+		client.Transport = t
+	}
+	se.client = client
+	return nil
+}
+
+// newTransport returns the HTTP transport used when no HTTP client is given.
+// Unlike http.DefaultTransport, it keeps enough idle connections per node to
+// serve maxInFlight concurrent requests (defaultMaxIdleConnsPerHost if 0).
+func newTransport(maxInFlight int) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	if maxInFlight > 0 {
+		t.MaxIdleConnsPerHost = maxInFlight
+	}
+	t.MaxIdleConns = 0 // no limit, MaxIdleConnsPerHost applies
+	return t
+}
+
+// roundTrip sends a single HTTP request to url.
+func (se *ElasticSearch) roundTrip(ctx context.Context, url string, m HttpMethod, body []byte, attempt int) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, string(m), url, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, values := range se.header {
+		req.Header[key] = values
+	}
+	if se.tracer == nil {
+		return se.client.Do(req)
+	}
+	t := &Trace{Method: m, Url: url, Body: body, Attempt: attempt}
+	se.tracer.Request(t)
+	start := time.Now()
+	resp, err := se.client.Do(req)
+	t.Duration = time.Since(start)
+	t.Err = err
+	if resp != nil {
+		t.Status = resp.StatusCode
+	}
+	se.tracer.Response(t)
+	return resp, err
+}
+
+// responseBody counts the bytes read from a response body and releases the
+// resources of the request once it is closed.
+type responseBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	done func(n int64)
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.n) })
+	return err
+}
+
 // Sends HTTP request to search engine
-func (se *ElasticSearch) sendRequest(m HttpMethod, path string, body io.Reader) error {
-	resp, err := se.sendRequestAndGetResponse(m, path, body)
-	if err == nil && resp != nil && resp.Body != nil {
+func (se *ElasticSearch) sendRequest(ctx context.Context, op string, m HttpMethod, path string, body io.Reader) error {
+	resp, err := se.sendRequestAndGetResponse(ctx, op, m, path, body)
+	if err == nil {
 		resp.Body.Close()
 	}
 	return err
 }
 
-// Sends HTTP request to search engine
-func (se *ElasticSearch) sendRequestAndGetResponse(m HttpMethod, path string, body io.Reader) (*http.Response, error) {
-	se.lock <- true
-	defer func() { <-se.lock }()
-	req, err := http.NewRequest(string(m), path, body)
-	if err != nil {
-		return nil, err
+// Sends HTTP request to search engine. op is the name of the API call the
+// request is made for, path is relative to the node's base URL. The request is
+// bound to ctx: it is aborted as soon as ctx is canceled or its deadline
+// expires.
+// When ES replies with an error status, the response is returned along with
+// the error so that its status code can be checked, but its body is already
+// closed.
+func (se *ElasticSearch) sendRequestAndGetResponse(ctx context.Context, op string, m HttpMethod, path string, body io.Reader) (*http.Response, error) {
+	return se.send(ctx, op, m, path, body, m != POST, se.retry)
+}
+
+// send is like sendRequestAndGetResponse, but retries the request according
+// to retry, which may be nil. idempotent tells whether the request can be
+// replayed once executed.
+func (se *ElasticSearch) send(ctx context.Context, op string, m HttpMethod, path string, body io.Reader, idempotent bool, retry *RetryPolicy) (*http.Response, error) {
+	if ctx == nil {
+		return nil, errors.New("nil context")
+	}
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = ioutil.ReadAll(body); err != nil {
+			return nil, err
+		}
+	}
+	se.sniffIfNeeded()
+	cancel := context.CancelFunc(func() {})
+	if se.timeout > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, se.timeout, errRequestTimeout)
+	}
+	start := time.Now()
+	var resp *http.Response
+	var release func()
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		resp, release, err = se.dispatch(ctx, op, m, path, data, attempt)
+		if ctx.Err() != nil || !retry.retryable(idempotent, resp, err, attempt) {
+			break
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			resp = nil
+		}
+		release()
+		if err = sleepContext(ctx, retry.backoff(attempt)); err != nil {
+			break
+		}
+	}
+	om := &OperationMetrics{
+		Operation: op,
+		Index:     strings.Trim(se.basePath, "/"),
+		Latency:   time.Since(start),
+		BytesOut:  int64(len(data)),
+		Retries:   attempt - 1,
 	}
-	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
+		cancel()
+		om.Err = err
+		se.observe(om)
 		return nil, err
 	}
-	err = se.handleResponse(resp)
+	om.Status = resp.StatusCode
+	// the slot of the request is held until its body is read
+	resp.Body = &responseBody{ReadCloser: resp.Body, done: func(n int64) {
+		cancel()
+		release()
+		om.BytesIn = n
+		se.observe(om)
+	}}
+	if err = se.handleResponse(resp); err != nil {
+		om.Err = err
+		resp.Body.Close()
+	}
 	return resp, err
 }
 
@@ -304,8 +735,8 @@ func (se *ElasticSearch) sendRequestAndGetResponse(m HttpMethod, path string, bo
 // // ScrollSearch retrieves some hits from a previously initiated search
 // // request. id is the search scroll id identifying the request returned by
 // // the PrepareScanSearch function. The result scroll is complete when no
-// // hits have been returned in the resultSet.
-// func (se *ElasticSearch) ScrollSearch(id ScrollId) (*resultSet, error) {
+// // hits have been returned in the ResultSet.
+// func (se *ElasticSearch) ScrollSearch(id ScrollId) (*ResultSet, error) {
 // 	search := Search{}
 // 	if err := se.c.Find(bson.M{"_id": string(id)}).One(&search); err != nil {
 // 		return nil, err
@@ -320,7 +751,7 @@ func (se *ElasticSearch) sendRequestAndGetResponse(m HttpMethod, path string, bo
 // 		return nil, err
 // 	}
 // 	dec := json.NewDecoder(resp.Body)
-// 	var rset = new(resultSet)
+// 	var rset = new(ResultSet)
 // 	err = dec.Decode(rset)
 // 	if err != nil {
 // 		return nil, err
@@ -1,20 +1,86 @@
 package goose
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
 
-	// 	"testing"
+	"github.com/gotsunami/goose/goosetest"
 )
 
 const (
-	uri          = "http://localhost:9200/"
 	index        = "gooseindex"
 	index2       = "gooseindex2"
 	invalidindex = "UPPERCASE"
 	dlat         = 43.454834
 	dlong        = 3.757789
+	testVersion  = "1.7.5" // version of ES emulated by test servers
 )
 
+// uri of the ES server of the tests. Tests run against a fake server unless
+// GOOSE_TEST_URL gives the URL of a live one, like http://localhost:9200/.
+var uri = os.Getenv("GOOSE_TEST_URL")
+
+func TestMain(m *testing.M) {
+	if uri == "" {
+		s := goosetest.NewServer(goosetest.WithVersion(testVersion))
+		uri = s.URL + "/"
+		code := m.Run()
+		s.Close()
+		os.Exit(code)
+	}
+	os.Exit(m.Run())
+}
+
+// testVersions are the ES versions emulated by the goosetest servers of the
+// tests run against every dialect.
+var testVersions = []string{"1.7.5", "6.8.0", "7.10.2", "8.11.1"}
+
+// memoryEngine names the MemoryEngine in the engines of forEachEngine.
+const memoryEngine = "memory"
+
+// testEngines are testVersions and the MemoryEngine.
+var testEngines = append(testVersions[:len(testVersions):len(testVersions)], memoryEngine)
+
+// forEachEngine runs f as a subtest for each engine of engines, which are
+// ES versions or memoryEngine. An ES version runs f with a client created
+// with options of the test index of a goosetest server of this version,
+// closed at the end of the subtest. memoryEngine runs f with a new
+// MemoryEngine and a nil server.
+func forEachEngine(t *testing.T, engines []string, f func(t *testing.T, s *goosetest.Server, engine SearchEngine), options ...Option) {
+	t.Helper()
+	for _, name := range engines {
+		t.Run(name, func(t *testing.T) {
+			if name == memoryEngine {
+				f(t, nil, NewMemoryEngine())
+				return
+			}
+			s := goosetest.NewServer(goosetest.WithVersion(name))
+			t.Cleanup(s.Close)
+			es, err := NewElasticSearch(s.IndexURL(index), options...)
+			if err != nil {
+				t.Fatal("Cannot create client:", err)
+			}
+			f(t, s, es)
+		})
+	}
+}
+
+// perTypeIndex stores each type of objects in its own index in ES 8.x and
+// later, i.e gooseindex-dummyobject.
+var perTypeIndex = WithIndexResolver(func(typ string) string {
+	return index + "-" + typ[strings.LastIndex(typ, "_")+1:]
+})
+
 type DummyObject struct {
 	Id          int      `json:"id"`
 	Description string   `json:"description"`
@@ -26,6 +92,123 @@ func (d *DummyObject) Key() string {
 	return fmt.Sprintf("%d", d.Id)
 }
 
+// a hung ES node must not hang the caller once its context is done
+func TestContextDeadline(t *testing.T) {
+	hang := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(hang)
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := NewElasticSearchContext(ctx, u)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded error, got %v", err)
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	for _, max := range []int{0, 2} {
+		var cur, peak int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&cur, 1)
+			defer atomic.AddInt32(&cur, -1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+		}))
+
+		u, _ := url.Parse(ts.URL + "/" + index)
+		es, _ := NewElasticSearch(u, WithMaxInFlight(max), WithIndexCreation(false))
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := es.OpenIndex(); err != nil {
+					t.Error("Cannot open index:", err)
+				}
+			}()
+		}
+		wg.Wait()
+		ts.Close()
+
+		if max == 0 && peak < 2 {
+			t.Error("requests were serialized")
+		}
+		if max > 0 && peak > int32(max) {
+			t.Errorf("expected at most %d concurrent requests, got %d", max, peak)
+		}
+	}
+}
+
+// a request holds its slot until its response body is closed
+func TestMaxInFlightBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithMaxInFlight(1), WithIndexCreation(false))
+	resp, err := es.sendRequestAndGetResponse(context.Background(), "Get", GET, "/", nil)
+	if err != nil {
+		t.Fatal("Cannot send request:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = es.OpenIndexContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the request to wait for the slot, got %v", err)
+	}
+	resp.Body.Close()
+	if err = es.OpenIndex(); err != nil {
+		t.Error("slot not released with the response body:", err)
+	}
+}
+
+// the search type of concurrent searches must not leak from one to another
+func TestConcurrentSearchTypes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("search_type") == "count" {
+			fmt.Fprint(w, `{"hits":{"total":1}}`)
+		} else {
+			fmt.Fprint(w, `{"hits":{"total":2}}`)
+		}
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithVersion(testVersion), WithIndexCreation(false))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(count bool) {
+			defer wg.Done()
+			search, should := es.Search, 2
+			if count {
+				search, should = es.SearchCount, 1
+			}
+			rset, err := search(&DummyObject{}, nil)
+			if err != nil {
+				t.Error("Search fails:", err)
+			} else if rset.Hits.Total != should {
+				t.Errorf("wrong search type, expected %d hits, got %d", should, rset.Hits.Total)
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+}
+
 // var sid ScrollId
 
 // func TestPrepareScanSearch(t *testing.T) {
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"net/url"
 	"os"
-	"time"
 
 	"github.com/gotsunami/goose"
 )
@@ -32,7 +31,8 @@ func main() {
 		printErrAndExit(err, 1)
 	}
 
-	if es, err = goose.NewElasticSearch(u); err != nil {
+	// writes can be searched once they return
+	if es, err = goose.NewElasticSearch(u, goose.WithRefresh(goose.REFRESH_WAIT_FOR)); err != nil {
 		printErrAndExit(err, 2)
 	}
 	// No need to create index for HQs, NewElasticSearch did it
@@ -47,7 +47,6 @@ func main() {
 		printErrAndExit(err, 4)
 	}
 
-	time.Sleep(1)
 	hqf := HQ{Company: "Go Tsunami", Country: 33}
 	f, err := es.Get(&hqf)
 	if err != nil {
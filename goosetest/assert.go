@@ -0,0 +1,121 @@
+package goosetest
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// requested returns the number of recorded requests with method m and
+// path. An empty method matches every method. If path contains a query
+// string, the query parameters of the request must match too.
+func (s *Server) requested(m, path string) int {
+	path, query := splitQuery(path)
+	n := 0
+	for _, r := range s.Requests() {
+		if m != "" && r.Method != m || strings.TrimSuffix(r.Path, "/") != strings.TrimSuffix(path, "/") {
+			continue
+		}
+		if query != "" && r.Query.Encode() != query {
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// AssertRequested fails the test if no request with method m and path was
+// received.
+func (s *Server) AssertRequested(t testing.TB, m, path string) {
+	t.Helper()
+	if s.requested(m, path) == 0 {
+		t.Errorf("no %s %s request, got %v", m, path, s.Requests())
+	}
+}
+
+// AssertNotRequested fails the test if a request with method m and path was
+// received.
+func (s *Server) AssertNotRequested(t testing.TB, m, path string) {
+	t.Helper()
+	if n := s.requested(m, path); n > 0 {
+		t.Errorf("unexpected %s %s request (%d times)", m, path, n)
+	}
+}
+
+// AssertRequestCount fails the test if the number of requests with method m
+// and path is not n.
+func (s *Server) AssertRequestCount(t testing.TB, m, path string, n int) {
+	t.Helper()
+	if got := s.requested(m, path); got != n {
+		t.Errorf("expected %d %s %s requests, got %d", n, m, path, got)
+	}
+}
+
+// AssertDocument fails the test if the source of the document id of type
+// typ in the index name is not want marshaled to JSON. If typ is empty, the
+// document is looked for in every type.
+func (s *Server) AssertDocument(t testing.TB, name, typ, id string, want interface{}) {
+	t.Helper()
+	src, ok := s.Document(name, typ, id)
+	if !ok {
+		t.Errorf("document %s/%s/%s not found", name, typ, id)
+		return
+	}
+	b, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal("cannot marshal the expected document:", err)
+	}
+	var got, expected interface{}
+	json.Unmarshal(src, &got)
+	json.Unmarshal(b, &expected)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong document %s/%s/%s. Expected %s, got %s", name, typ, id, b, src)
+	}
+}
+
+// AssertNoDocument fails the test if the document id of type typ exists in
+// the index name.
+func (s *Server) AssertNoDocument(t testing.TB, name, typ, id string) {
+	t.Helper()
+	if src, ok := s.Document(name, typ, id); ok {
+		t.Errorf("unexpected document %s/%s/%s: %s", name, typ, id, src)
+	}
+}
+
+// AssertIndex fails the test if the index name does not exist.
+func (s *Server) AssertIndex(t testing.TB, name string) {
+	t.Helper()
+	s.mu.Lock()
+	_, ok := s.indices[name]
+	s.mu.Unlock()
+	if !ok {
+		t.Errorf("index %s does not exist", name)
+	}
+}
+
+// AssertNoIndex fails the test if the index name exists.
+func (s *Server) AssertNoIndex(t testing.TB, name string) {
+	t.Helper()
+	s.mu.Lock()
+	_, ok := s.indices[name]
+	s.mu.Unlock()
+	if ok {
+		t.Errorf("unexpected index %s", name)
+	}
+}
+
+// splitQuery splits path into the path itself and its encoded, sorted,
+// query string.
+func splitQuery(path string) (string, string) {
+	i := strings.IndexByte(path, '?')
+	if i < 0 {
+		return path, ""
+	}
+	q, err := url.ParseQuery(path[i+1:])
+	if err != nil {
+		return path[:i], path[i+1:]
+	}
+	return path[:i], q.Encode()
+}
@@ -0,0 +1,139 @@
+package goosetest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// bulkMeta is the metadata of a bulk action.
+type bulkMeta struct {
+	Index string `json:"_index"`
+	Type  string `json:"_type"`
+	Id    string `json:"_id"`
+
+	Version       *int64 `json:"version"`
+	LegacyVersion *int64 `json:"_version"` // before ES 7.x
+	IfSeqNo       *int64 `json:"if_seq_no"`
+	IfPrimaryTerm *int64 `json:"if_primary_term"`
+
+	Routing       string `json:"routing"`
+	LegacyRouting string `json:"_routing"` // before ES 7.x
+	Parent        string `json:"parent"`
+	LegacyParent  string `json:"_parent"` // before ES 7.x
+}
+
+// bulkPrecondition returns the precondition of the write of a bulk action, nil
+// if there is none.
+func (s *Server) bulkPrecondition(meta bulkMeta) (*precondition, *esError) {
+	q := make(url.Values)
+	version := meta.Version
+	if meta.LegacyVersion != nil {
+		if s.major >= 7 {
+			return nil, badRequest("Action/metadata line contains an unknown parameter [_version]")
+		}
+		version = meta.LegacyVersion
+	}
+	if version != nil {
+		q.Set("version", strconv.FormatInt(*version, 10))
+	}
+	if meta.IfSeqNo != nil {
+		q.Set("if_seq_no", strconv.FormatInt(*meta.IfSeqNo, 10))
+	}
+	if meta.IfPrimaryTerm != nil {
+		q.Set("if_primary_term", strconv.FormatInt(*meta.IfPrimaryTerm, 10))
+	}
+	return s.parsePrecondition(q)
+}
+
+// handleBulk executes the actions of a bulk request on the index name and
+// the type typ unless the actions give theirs.
+func (s *Server) handleBulk(name, typ string, body []byte) (int, interface{}, *esError) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+	var items []object
+	failed := false
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var action map[string]bulkMeta
+		if err := json.Unmarshal(line, &action); err != nil || len(action) != 1 {
+			return 0, nil, badRequest("malformed bulk action line %s", line)
+		}
+		for op, meta := range action {
+			if meta.Index == "" {
+				meta.Index = name
+			}
+			if meta.Type == "" {
+				meta.Type = typ
+			}
+			if meta.Type == "" {
+				meta.Type = defaultType
+			}
+			var source []byte
+			if op != "delete" {
+				if !scanner.Scan() {
+					return 0, nil, badRequest("missing source of bulk %s action", op)
+				}
+				source = append([]byte(nil), scanner.Bytes()...)
+			}
+			status, result, e := s.bulkAction(op, meta, source)
+			item := s.docMeta(meta.Index, meta.Type, meta.Id)
+			if e != nil {
+				failed = true
+				status = e.status
+				item["error"] = s.errorBody(e)
+			} else {
+				item = result
+			}
+			item["status"] = status
+			items = append(items, object{op: item})
+		}
+	}
+	return http.StatusOK, object{"took": 1, "errors": failed, "items": items}, nil
+}
+
+// bulkAction executes a bulk action.
+func (s *Server) bulkAction(op string, meta bulkMeta, source []byte) (int, object, *esError) {
+	p, e := s.bulkPrecondition(meta)
+	if e != nil {
+		return 0, nil, e
+	}
+	if (meta.LegacyRouting != "" || meta.LegacyParent != "") && s.major >= 7 {
+		return 0, nil, badRequest("Action/metadata line contains an unknown parameter [_routing] or [_parent]")
+	}
+	routing, e := s.parseRouting(meta.Routing+meta.LegacyRouting, meta.Parent+meta.LegacyParent)
+	if e != nil {
+		return 0, nil, e
+	}
+	switch op {
+	case "index", "create":
+		id := meta.Id
+		if id == "" {
+			id = generateId()
+		}
+		status, reply, e := s.indexDoc(meta.Index, meta.Type, id, source, op == "create", p)
+		if e == nil {
+			s.lookup(meta.Index, meta.Type, id).routing = routing
+		}
+		return status, reply, e
+	case "update":
+		var req object
+		if err := json.Unmarshal(source, &req); err != nil {
+			return 0, nil, parseError(err)
+		}
+		status, reply, e := s.updateDoc(meta.Index, meta.Type, meta.Id, req, p)
+		if status == http.StatusCreated {
+			s.lookup(meta.Index, meta.Type, meta.Id).routing = routing
+		}
+		return status, reply, e
+	case "delete":
+		return s.deleteDoc(meta.Index, meta.Type, meta.Id, p)
+	}
+	return 0, nil, badRequest("unknown bulk action %s", op)
+}
@@ -0,0 +1,181 @@
+package goosetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/gotsunami/goose/internal/dsl"
+)
+
+// hit is a document matched by a query.
+type hit struct {
+	dsl.Document
+	typ string
+	doc *document
+}
+
+func (s *Server) handleSearch(name, typ string, q url.Values, body []byte) (int, interface{}, *esError) {
+	req, err := dsl.ParseRequest(body)
+	if err != nil {
+		return 0, nil, parseError(err)
+	}
+	hits, e := s.match(name, typ, req.Query)
+	if e != nil {
+		return 0, nil, e
+	}
+	sorter, err := dsl.NewSorter(req.Sort)
+	if err != nil {
+		return 0, nil, badRequest("%v", err)
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return sorter.Less(hits[i].Source, hits[j].Source) })
+
+	from, size := 0, 10
+	if req.From != nil {
+		from = *req.From
+	}
+	if req.Size != nil {
+		size = *req.Size
+	}
+	if v, err := strconv.Atoi(q.Get("from")); err == nil {
+		from = v
+	}
+	if v, err := strconv.Atoi(q.Get("size")); err == nil {
+		size = v
+	}
+	if q.Get("search_type") == "count" {
+		size = 0
+	}
+	page := make([]object, 0, size)
+	for i := from; i < len(hits) && i < from+size; i++ {
+		h := hits[i]
+		r := s.docMeta(name, h.typ, h.Id)
+		r["_score"] = 1.0
+		r["_source"] = h.doc.source
+		page = append(page, r)
+	}
+
+	var total interface{} = len(hits)
+	if s.major >= 7 {
+		total = object{"value": len(hits), "relation": "eq"}
+	}
+	reply := object{
+		"took":      1,
+		"timed_out": false,
+		"_shards":   object{"total": 1, "successful": 1, "failed": 0},
+		"hits":      object{"total": total, "max_score": 1.0, "hits": page},
+	}
+	sources := make([]map[string]interface{}, len(hits))
+	for i, h := range hits {
+		sources[i] = h.Source
+	}
+	if len(req.Facets) > 0 {
+		facets := object{}
+		for fname, raw := range req.Facets {
+			field, fsize, err := dsl.TermsParams(raw)
+			if err != nil {
+				return 0, nil, badRequest("%v", err)
+			}
+			terms, other, count := dsl.TermCounts(sources, field, fsize)
+			list := make([]object, len(terms))
+			for i, t := range terms {
+				list[i] = object{"term": t.Value, "count": t.Count}
+			}
+			facets[fname] = object{"_type": "terms", "missing": 0, "total": count, "other": other, "terms": list}
+		}
+		reply["facets"] = facets
+	}
+	if len(req.Aggs) > 0 {
+		aggs := object{}
+		for aname, raw := range req.Aggs {
+			field, asize, err := dsl.TermsParams(raw)
+			if err != nil {
+				return 0, nil, badRequest("%v", err)
+			}
+			terms, other, _ := dsl.TermCounts(sources, field, asize)
+			buckets := make([]object, len(terms))
+			for i, t := range terms {
+				buckets[i] = object{"key": t.Value, "doc_count": t.Count}
+			}
+			aggs[aname] = object{"doc_count_error_upper_bound": 0, "sum_other_doc_count": other, "buckets": buckets}
+		}
+		reply["aggregations"] = aggs
+	}
+	return http.StatusOK, reply, nil
+}
+
+func (s *Server) handleCount(name, typ string, body []byte) (int, interface{}, *esError) {
+	req, err := dsl.ParseRequest(body)
+	if err != nil {
+		return 0, nil, parseError(err)
+	}
+	hits, e := s.match(name, typ, req.Query)
+	if e != nil {
+		return 0, nil, e
+	}
+	return http.StatusOK, object{
+		"count":   len(hits),
+		"_shards": object{"total": 1, "successful": 1, "failed": 0},
+	}, nil
+}
+
+func (s *Server) handleDeleteByQuery(name, typ string, body []byte) (int, interface{}, *esError) {
+	req, err := dsl.ParseRequest(body)
+	if err != nil {
+		return 0, nil, parseError(err)
+	}
+	hits, e := s.match(name, typ, req.Query)
+	if e != nil {
+		return 0, nil, e
+	}
+	idx := s.indices[name]
+	for _, h := range hits {
+		delete(idx.docs[h.typ], h.Id)
+		idx.seqNo++
+	}
+	shards := object{"total": 1, "successful": 1, "failed": 0}
+	if s.major < 2 {
+		return http.StatusOK, object{"_indices": object{name: object{"_shards": shards}}}, nil
+	}
+	return http.StatusOK, object{
+		"took":     1,
+		"total":    len(hits),
+		"deleted":  len(hits),
+		"failures": []object{},
+	}, nil
+}
+
+// match returns the documents of type typ, or of every type, in the index
+// name matching the query, in insertion order.
+func (s *Server) match(name, typ string, query object) ([]*hit, *esError) {
+	idx, e := s.openIndex(name)
+	if e != nil {
+		return nil, e
+	}
+	var hits []*hit
+	for t, docs := range idx.docs {
+		if typ != "" && t != typ {
+			continue
+		}
+		for id, d := range docs {
+			h := &hit{Document: dsl.Document{Id: id}, typ: t, doc: d}
+			if err := json.Unmarshal(d.source, &h.Source); err != nil {
+				return nil, parseError(err)
+			}
+			if query != nil {
+				ok, err := dsl.Match(query, &h.Document)
+				if err != nil {
+					return nil, &esError{400, "QueryParsingException", "parsing_exception", err.Error(), name}
+				}
+				if !ok {
+					continue
+				}
+			}
+			hits = append(hits, h)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].doc.seq < hits[j].doc.seq })
+	return hits, nil
+}
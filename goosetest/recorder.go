@@ -0,0 +1,252 @@
+package goosetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Mode is the mode of a Recorder.
+type Mode int
+
+const (
+	// MODE_REPLAY replies with the recorded interactions only. Requests
+	// without a recorded interaction fail.
+	MODE_REPLAY Mode = iota
+	// MODE_RECORD sends every request to ES and records the interactions,
+	// replacing the previous ones.
+	MODE_RECORD
+	// MODE_REPLAY_OR_RECORD replays the recorded interactions and records the
+	// requests without one.
+	MODE_REPLAY_OR_RECORD
+)
+
+// Interaction is a request sent to ES and the response it got, as stored in
+// fixture files.
+type Interaction struct {
+	Method   string      `json:"method"`
+	Path     string      `json:"path"` // with the query string, if any
+	Body     string      `json:"body,omitempty"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header,omitempty"`
+	Response string      `json:"response"`
+
+	replayed bool
+}
+
+// Recorder is an http.RoundTripper recording the interactions with ES into a
+// fixture file and replaying them later, so that integration tests run
+// offline once recorded. Give it to goose.WithTransport:
+//
+//	rec, err := goosetest.NewRecorder("testdata/crud.json", goosetest.MODE_REPLAY, nil)
+//	...
+//	es, err := goose.NewElasticSearch(u, goose.WithTransport(rec))
+//	...
+//	defer rec.Save()
+//
+// Requests match an interaction with the same method, path, query parameters
+// and body. JSON bodies are compared once normalized, so that the order of
+// the keys and the spacing do not matter. The host of the request is
+// ignored. Identical requests are replayed in the order they were recorded,
+// the last one being replayed again once all were.
+//
+// Request headers are not recorded, which keeps credentials out of the
+// fixtures.
+type Recorder struct {
+	path string
+	mode Mode
+	rt   http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []*Interaction
+}
+
+// NewRecorder returns a Recorder in mode for the fixture file path. rt is
+// the transport of the recorded requests, http.DefaultTransport if nil. The
+// fixture file must exist in MODE_REPLAY.
+func NewRecorder(path string, mode Mode, rt http.RoundTripper) (*Recorder, error) {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	r := &Recorder{path: path, mode: mode, rt: rt}
+	if mode == MODE_RECORD {
+		return r, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && mode == MODE_REPLAY_OR_RECORD {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &r.interactions); err != nil {
+		return nil, fmt.Errorf("invalid fixture file %s: %v", path, err)
+	}
+	return r, nil
+}
+
+// RoundTrip replays or records the request req.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	path := req.URL.Path
+	if q := req.URL.Query(); len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	if r.mode != MODE_RECORD {
+		r.mu.Lock()
+		in := r.find(req.Method, path, body)
+		r.mu.Unlock()
+		if in != nil {
+			return in.response(req), nil
+		}
+		if r.mode == MODE_REPLAY {
+			return nil, fmt.Errorf("goosetest: no recorded interaction for %s %s in %s", req.Method, path, r.path)
+		}
+	}
+
+	resp, err := r.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	in := &Interaction{
+		Method:   req.Method,
+		Path:     path,
+		Body:     string(body),
+		Status:   resp.StatusCode,
+		Header:   resp.Header.Clone(),
+		Response: string(data),
+		replayed: true,
+	}
+	r.mu.Lock()
+	r.interactions = append(r.interactions, in)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// find returns the interaction to replay for a request, nil if there is
+// none. The caller must hold the lock.
+func (r *Recorder) find(method, path string, body []byte) *Interaction {
+	norm := normalize(string(body))
+	var last *Interaction
+	for _, in := range r.interactions {
+		if in.Method != method || in.Path != path || normalize(in.Body) != norm {
+			continue
+		}
+		if !in.replayed {
+			in.replayed = true
+			return in
+		}
+		last = in
+	}
+	return last
+}
+
+// response returns the recorded response to req.
+func (in *Interaction) response(req *http.Request) *http.Response {
+	header := in.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+		StatusCode:    in.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(in.Response)),
+		ContentLength: int64(len(in.Response)),
+		Request:       req,
+	}
+}
+
+// normalize returns the canonical form of a JSON or NDJSON body: keys are
+// sorted and spaces removed. Other bodies are returned as is.
+func normalize(body string) string {
+	if strings.TrimSpace(body) == "" {
+		return ""
+	}
+	if norm, ok := normalizeJSON(body); ok {
+		return norm
+	}
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	for i, line := range lines {
+		norm, ok := normalizeJSON(line)
+		if !ok {
+			return body
+		}
+		lines[i] = norm
+	}
+	return strings.Join(lines, "\n")
+}
+
+// normalizeJSON returns the canonical form of a JSON value, and false if s
+// is not a single JSON value.
+func normalizeJSON(s string) (string, bool) {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil || d.More() {
+		return "", false
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// Unreplayed returns the recorded interactions which were not replayed, in
+// order. A test replaying all the interactions of its fixture file sends the
+// same requests as when it was recorded.
+func (r *Recorder) Unreplayed() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var list []Interaction
+	for _, in := range r.interactions {
+		if !in.replayed {
+			list = append(list, *in)
+		}
+	}
+	return list
+}
+
+// Save writes the recorded interactions to the fixture file, creating its
+// directory if needed. It does nothing in MODE_REPLAY.
+func (r *Recorder) Save() error {
+	if r.mode == MODE_REPLAY {
+		return nil
+	}
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.interactions, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,126 @@
+package goosetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gotsunami/goose"
+)
+
+// crud runs a few operations, returning the description of their results.
+func crud(t *testing.T, u *url.URL, rec *Recorder) string {
+	es, err := goose.NewElasticSearch(u, goose.WithTransport(rec))
+	if err != nil {
+		t.Fatal("Cannot create client:", err)
+	}
+	if err := es.Insert(shops[0]); err != nil {
+		t.Fatal("Cannot insert:", err)
+	}
+	got := &shop{Id: 1}
+	found, err := es.Get(got)
+	if !found || err != nil {
+		t.Fatal("Cannot get:", err)
+	}
+	_, err = es.Get(&shop{Id: 2})
+	rset, _ := es.Search(&shop{}, goose.NewQueryBuilder().SetTerm("category", "watches"))
+	return got.Name + " " + err.Error() + " " + rset.Hits.Data[0].Id
+}
+
+func TestRecorder(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "testdata", "crud.json")
+	s := NewServer()
+	rec, err := NewRecorder(fixture, MODE_RECORD, nil)
+	if err != nil {
+		t.Fatal("Cannot create recorder:", err)
+	}
+	recorded := crud(t, s.IndexURL("shops"), rec)
+	if err := rec.Save(); err != nil {
+		t.Fatal("Cannot save fixture:", err)
+	}
+	s.Close()
+	data, _ := ioutil.ReadFile(fixture)
+	if !strings.Contains(string(data), `"path": "/shops/shop/1"`) {
+		t.Errorf("interaction not recorded in %s", data)
+	}
+
+	// the server is closed: the replayed responses come from the fixture
+	rec, err = NewRecorder(fixture, MODE_REPLAY, nil)
+	if err != nil {
+		t.Fatal("Cannot load fixture:", err)
+	}
+	u, _ := url.Parse("http://localhost:9/shops/")
+	if replayed := crud(t, u, rec); replayed != recorded {
+		t.Errorf("wrong replayed results. Expected %q, got %q", recorded, replayed)
+	}
+	if left := rec.Unreplayed(); len(left) != 0 {
+		t.Errorf("interactions not replayed: %v", left)
+	}
+
+	req, _ := http.NewRequest("DELETE", "http://localhost:9/shops/", nil)
+	if _, err := rec.RoundTrip(req); err == nil || !strings.Contains(err.Error(), "DELETE /shops/") {
+		t.Error("expected an unknown interaction error, got", err)
+	}
+}
+
+func TestRecorderMatching(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "search.json")
+	fixtures := `[
+  {"method": "GET", "path": "/shops/_search?q=a&size=1", "body": "{\"query\": {\"term\": {\"a\": 1}}, \"size\": 1}", "status": 200, "response": "first"},
+  {"method": "GET", "path": "/shops/_search?q=a&size=1", "body": "{\"size\":1,\"query\":{\"term\":{\"a\":1}}}", "status": 200, "response": "second"},
+  {"method": "POST", "path": "/_bulk", "body": "{\"index\": {}}\n{\"a\": 1}\n", "status": 404, "response": "bulk"}
+]`
+	if err := ioutil.WriteFile(fixture, []byte(fixtures), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := NewRecorder(fixture, MODE_REPLAY, nil)
+	if err != nil {
+		t.Fatal("Cannot load fixture:", err)
+	}
+	send := func(m, u, body string) (int, string) {
+		req, _ := http.NewRequest(m, u, strings.NewReader(body))
+		resp, err := rec.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", m, u, err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	search := `{"size":1,"query":{"term":{"a":1}}}`
+	for _, should := range []string{"first", "second", "second"} {
+		if _, got := send("GET", "http://other:9200/shops/_search?size=1&q=a", search); got != should {
+			t.Errorf("wrong replayed response. Expected %s, got %s", should, got)
+		}
+	}
+	if status, got := send("POST", "http://localhost:9200/_bulk", `{"index":{}}`+"\n"+`{ "a" : 1 }`+"\n"); status != 404 || got != "bulk" {
+		t.Errorf("wrong bulk response %d %s", status, got)
+	}
+
+	if _, err := NewRecorder(filepath.Join(t.TempDir(), "missing.json"), MODE_REPLAY, nil); err == nil {
+		t.Error("missing fixture file accepted")
+	}
+}
+
+func TestRecorderReplayOrRecord(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "mixed.json")
+	s := NewServer()
+	defer s.Close()
+	for i := 0; i < 2; i++ {
+		rec, err := NewRecorder(fixture, MODE_REPLAY_OR_RECORD, nil)
+		if err != nil {
+			t.Fatal("Cannot create recorder:", err)
+		}
+		s.ClearRequests()
+		crud(t, s.IndexURL("shops"), rec)
+		if err := rec.Save(); err != nil {
+			t.Fatal("Cannot save fixture:", err)
+		}
+		if n := len(s.Requests()); i == 0 && n == 0 || i == 1 && n != 0 {
+			t.Errorf("run %d: %d requests sent to the server", i, n)
+		}
+	}
+}
@@ -0,0 +1,960 @@
+// Package goosetest provides an in-process fake ElasticSearch server for the
+// unit tests of goose and of its users.
+//
+// The fake server emulates the subset of the ES REST API used by goose: index
+// creation, deletion, opening, closing, _refresh and _stats, document PUT, GET, DELETE
+// and _update (with a subset of the scripts), _bulk, _mget, mappings, _search, _count
+// and delete by query. Documents are kept in memory and searches evaluate the usual query DSL clauses
+// (match_all, term, terms, match, match_phrase, query_string, range, exists,
+// ids, bool, filtered and geo filters) with sorting, paging and terms facets
+// or aggregations. Every request is recorded for later assertions.
+//
+// For example:
+//
+//	s := goosetest.NewServer()
+//	defer s.Close()
+//	es, err := goose.NewElasticSearch(s.IndexURL("myindex"))
+//	...
+//	s.AssertRequested(t, "PUT", "/myindex")
+//	s.AssertDocument(t, "myindex", "", obj.Key(), obj)
+//
+// For tests needing a real ES cluster, a Recorder records the interactions
+// with the cluster into a fixture file once, and replays them offline later.
+package goosetest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gotsunami/goose/internal/dsl"
+)
+
+// DefaultVersion is the ES version emulated by default.
+const DefaultVersion = "7.10.2"
+
+// defaultType is the type of the documents indexed with typeless paths.
+const defaultType = "_doc"
+
+type object = map[string]interface{}
+
+// Server is a fake ES server listening on a local loopback address.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	version  string
+	major    int
+	indices  map[string]*index
+	requests []Request
+	failures []int // status codes of the next injected failures
+	seq      int64 // insertion order of the documents
+}
+
+// Request is a request received by the server.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// String returns the request as "METHOD /path?query".
+func (r Request) String() string {
+	if len(r.Query) == 0 {
+		return r.Method + " " + r.Path
+	}
+	return r.Method + " " + r.Path + "?" + r.Query.Encode()
+}
+
+// index is an ES index.
+type index struct {
+	closed   bool
+	mappings map[string]json.RawMessage      // by type
+	docs     map[string]map[string]*document // by type and id
+	seqNo    int64
+}
+
+// document is a stored document.
+type document struct {
+	source  json.RawMessage
+	version int64
+	seqNo   int64
+	seq     int64
+	routing string
+}
+
+// Option is a server configuration option.
+type Option func(*Server)
+
+// WithVersion makes the server emulate the ES version v, 7.10.2 by default.
+// It changes the paths, the query DSL and the replies of the server the way
+// they change across ES versions.
+func WithVersion(v string) Option {
+	return func(s *Server) {
+		s.version = v
+	}
+}
+
+// NewServer starts and returns a new fake ES server with no index. The
+// caller should call Close when finished, to shut it down.
+func NewServer(options ...Option) *Server {
+	s := &Server{version: DefaultVersion, indices: make(map[string]*index)}
+	for _, option := range options {
+		option(s)
+	}
+	s.major, _ = strconv.Atoi(strings.SplitN(s.version, ".", 2)[0])
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// IndexURL returns the URL of the index name, to give to
+// goose.NewElasticSearch.
+func (s *Server) IndexURL(name string) *url.URL {
+	u, _ := url.Parse(s.URL + "/" + name + "/")
+	return u
+}
+
+// Requests returns the requests received by the server, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// ClearRequests forgets the requests received so far.
+func (s *Server) ClearRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+// FailNext makes the server reply to the next len(statuses) requests with an
+// error of the given HTTP statuses, without executing them.
+func (s *Server) FailNext(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statuses...)
+}
+
+// CreateIndex creates the index name if it does not exist.
+func (s *Server) CreateIndex(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.createIndex(name)
+}
+
+// Put stores src, marshaled to JSON, as the document id of type typ in the
+// index name, which is created if needed. typ is "_doc" if empty.
+func (s *Server) Put(name, typ, id string, src interface{}) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	if typ == "" {
+		typ = defaultType
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, _, e := s.indexDoc(name, typ, id, b, false, nil); e != nil {
+		return e
+	}
+	return nil
+}
+
+// Document returns the source of the document id of type typ in the index
+// name. If typ is empty, the document is looked for in every type.
+func (s *Server) Document(name, typ, id string) (json.RawMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d := s.lookup(name, typ, id); d != nil {
+		return d.source, true
+	}
+	return nil, false
+}
+
+// Count returns the number of documents of type typ in the index name, or of
+// every type if typ is empty.
+func (s *Server) Count(name, typ string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx, ok := s.indices[name]
+	if !ok {
+		return 0
+	}
+	n := 0
+	for t, docs := range idx.docs {
+		if typ == "" || t == typ {
+			n += len(docs)
+		}
+	}
+	return n
+}
+
+// Indices returns the sorted names of the existing indices.
+func (s *Server) Indices() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.indices))
+	for name := range s.indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ServeHTTP records and executes a request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		s.writeError(w, &esError{status, "InjectedFailure", "injected_failure", "failure injected by goosetest", ""})
+		return
+	}
+	status, reply, e := s.route(r.Method, r.URL, body)
+	if e != nil {
+		s.writeError(w, e)
+		return
+	}
+	s.write(w, status, reply)
+}
+
+// route executes a request, returning the HTTP status and the reply.
+func (s *Server) route(method string, u *url.URL, body []byte) (int, interface{}, *esError) {
+	path := strings.Trim(u.Path, "/")
+	if path == "" {
+		return http.StatusOK, s.info(), nil
+	}
+	segments := strings.Split(path, "/")
+	if segments[0] == "_nodes" {
+		return http.StatusOK, s.nodes(), nil
+	}
+	name, rest := segments[0], segments[1:]
+	q := u.Query()
+	if e := s.checkRefresh(q.Get("refresh")); e != nil {
+		return 0, nil, e
+	}
+	if len(rest) == 0 {
+		switch method {
+		case "PUT", "POST":
+			return s.handleCreateIndex(name)
+		case "DELETE":
+			return s.handleDeleteIndex(name)
+		case "GET", "HEAD":
+			if e := s.checkIndex(name); e != nil {
+				return 0, nil, e
+			}
+			return http.StatusOK, object{name: object{}}, nil
+		}
+		return 0, nil, badRequest("unsupported method %s on index %s", method, name)
+	}
+
+	// index level actions
+	switch rest[0] {
+	case "_stats":
+		if e := s.checkIndex(name); e != nil {
+			return 0, nil, e
+		}
+		return http.StatusOK, object{"_all": object{}, "indices": object{name: object{}}}, nil
+	case "_open", "_close":
+		return s.handleOpenClose(name, rest[0] == "_open")
+	case "_refresh":
+		for _, name := range strings.Split(name, ",") {
+			if e := s.checkIndex(name); e != nil && q.Get("ignore_unavailable") != "true" {
+				return 0, nil, e
+			}
+		}
+		return http.StatusOK, object{"_shards": object{"total": 1, "successful": 1, "failed": 0}}, nil
+	case "_doc", "_create", "_update":
+		if len(rest) < 2 {
+			if rest[0] == "_doc" && method == "POST" {
+				return s.handleDocument(method, name, defaultType, "", q, body)
+			}
+			return 0, nil, badRequest("missing document id")
+		}
+		switch rest[0] {
+		case "_create":
+			q.Set("op_type", "create")
+		case "_update":
+			return s.handleUpdate(name, defaultType, rest[1], q, body)
+		}
+		return s.handleDocument(method, name, defaultType, rest[1], q, body)
+	}
+	if strings.HasPrefix(rest[0], "_") {
+		return s.routeType(method, name, "", rest[0], q, body)
+	}
+
+	typ, rest := rest[0], rest[1:]
+	if len(rest) == 0 {
+		if method != "POST" {
+			return 0, nil, badRequest("unsupported method %s on type %s", method, typ)
+		}
+		return s.handleDocument(method, name, typ, "", q, body)
+	}
+	if strings.HasPrefix(rest[0], "_") {
+		return s.routeType(method, name, typ, rest[0], q, body)
+	}
+	id := rest[0]
+	if len(rest) > 1 {
+		switch rest[1] {
+		case "_update":
+			return s.handleUpdate(name, typ, id, q, body)
+		case "_create":
+			q.Set("op_type", "create")
+		default:
+			return 0, nil, badRequest("unsupported action %s", rest[1])
+		}
+	}
+	return s.handleDocument(method, name, typ, id, q, body)
+}
+
+// routeType executes an action on the documents of type typ, or of every
+// type if typ is empty.
+func (s *Server) routeType(method, name, typ, action string, q url.Values, body []byte) (int, interface{}, *esError) {
+	switch action {
+	case "_search":
+		return s.handleSearch(name, typ, q, body)
+	case "_count":
+		return s.handleCount(name, typ, body)
+	case "_bulk":
+		return s.handleBulk(name, typ, body)
+	case "_mget":
+		return s.handleMget(name, typ, body)
+	case "_mapping", "_mappings":
+		switch method {
+		case "PUT", "POST":
+			return s.handlePutMapping(name, typ, body)
+		case "DELETE":
+			return s.handleDeleteMapping(name, typ)
+		}
+		return s.handleGetMapping(name, typ)
+	case "_query":
+		if method != "DELETE" {
+			return 0, nil, badRequest("unsupported method %s on _query", method)
+		}
+		return s.handleDeleteByQuery(name, typ, body)
+	case "_delete_by_query":
+		return s.handleDeleteByQuery(name, typ, body)
+	}
+	return 0, nil, badRequest("unsupported action %s", action)
+}
+
+// info is the reply to GET /.
+func (s *Server) info() object {
+	return object{
+		"name":         "goosetest",
+		"cluster_name": "goosetest",
+		"version":      object{"number": s.version},
+		"tagline":      "You Know, for Search",
+	}
+}
+
+// nodes is the reply to GET /_nodes/http: the server is the only node.
+func (s *Server) nodes() object {
+	host := strings.TrimPrefix(s.URL, "http://")
+	return object{"nodes": object{"goosetest": object{
+		"http_address": "inet[/" + host + "]",
+		"http":         object{"publish_address": host},
+	}}}
+}
+
+func (s *Server) handleCreateIndex(name string) (int, interface{}, *esError) {
+	if _, ok := s.indices[name]; ok {
+		if s.major < 6 {
+			return 0, nil, &esError{400, "IndexAlreadyExistsException", "index_already_exists_exception",
+				fmt.Sprintf("[%s] already exists", name), name}
+		}
+		return 0, nil, &esError{400, "IndexAlreadyExistsException", "resource_already_exists_exception",
+			fmt.Sprintf("index [%s] already exists", name), name}
+	}
+	if name != strings.ToLower(name) {
+		return 0, nil, &esError{400, "InvalidIndexNameException", "invalid_index_name_exception",
+			fmt.Sprintf("Invalid index name [%s], must be lowercase", name), name}
+	}
+	s.createIndex(name)
+	return http.StatusOK, object{"acknowledged": true}, nil
+}
+
+func (s *Server) handleDeleteIndex(name string) (int, interface{}, *esError) {
+	if e := s.checkIndex(name); e != nil {
+		return 0, nil, e
+	}
+	delete(s.indices, name)
+	return http.StatusOK, object{"acknowledged": true}, nil
+}
+
+func (s *Server) handleOpenClose(name string, open bool) (int, interface{}, *esError) {
+	idx, ok := s.indices[name]
+	if !ok {
+		return 0, nil, indexMissing(name)
+	}
+	idx.closed = !open
+	return http.StatusOK, object{"acknowledged": true}, nil
+}
+
+func (s *Server) handlePutMapping(name, typ string, body []byte) (int, interface{}, *esError) {
+	idx, e := s.openIndex(name)
+	if e != nil {
+		return 0, nil, e
+	}
+	var mapping object
+	if err := json.Unmarshal(body, &mapping); err != nil {
+		return 0, nil, parseError(err)
+	}
+	if typ == "" {
+		typ = defaultType
+	}
+	// the mapping can be wrapped in an object named after the type
+	if m, ok := mapping[typ].(object); ok && len(mapping) == 1 {
+		mapping = m
+	}
+	b, _ := json.Marshal(mapping)
+	idx.mappings[typ] = b
+	return http.StatusOK, object{"acknowledged": true}, nil
+}
+
+func (s *Server) handleGetMapping(name, typ string) (int, interface{}, *esError) {
+	idx, e := s.openIndex(name)
+	if e != nil {
+		return 0, nil, e
+	}
+	mappings := object{}
+	for t, m := range idx.mappings {
+		if typ == "" || t == typ {
+			mappings[t] = m
+		}
+	}
+	if s.major >= 7 && typ == "" {
+		if m, ok := mappings[defaultType]; ok {
+			return http.StatusOK, object{name: object{"mappings": m}}, nil
+		}
+	}
+	return http.StatusOK, object{name: object{"mappings": mappings}}, nil
+}
+
+func (s *Server) handleDeleteMapping(name, typ string) (int, interface{}, *esError) {
+	idx, e := s.openIndex(name)
+	if e != nil {
+		return 0, nil, e
+	}
+	if _, ok := idx.mappings[typ]; !ok {
+		return 0, nil, &esError{404, "TypeMissingException", "type_missing_exception",
+			fmt.Sprintf("[%s] type[[%s]] missing", name, typ), name}
+	}
+	delete(idx.mappings, typ)
+	delete(idx.docs, typ)
+	return http.StatusOK, object{"acknowledged": true}, nil
+}
+
+// handleDocument indexes, gets or deletes a document. Documents posted
+// without id get a generated one.
+func (s *Server) handleDocument(method, name, typ, id string, q url.Values, body []byte) (int, interface{}, *esError) {
+	p, e := s.parsePrecondition(q)
+	if e != nil {
+		return 0, nil, e
+	}
+	routing, e := s.parseRouting(q.Get("routing"), q.Get("parent"))
+	if e != nil {
+		return 0, nil, e
+	}
+	switch method {
+	case "PUT", "POST":
+		if id == "" {
+			id = generateId()
+		}
+		status, reply, e := s.indexDoc(name, typ, id, body, q.Get("op_type") == "create", p)
+		if e == nil {
+			s.lookup(name, typ, id).routing = routing
+		}
+		return status, reply, e
+	case "GET", "HEAD":
+		return s.getDoc(name, typ, id)
+	case "DELETE":
+		return s.deleteDoc(name, typ, id, p)
+	}
+	return 0, nil, badRequest("unsupported method %s on document %s", method, id)
+}
+
+func (s *Server) handleUpdate(name, typ, id string, q url.Values, body []byte) (int, interface{}, *esError) {
+	p, e := s.parsePrecondition(q)
+	if e != nil {
+		return 0, nil, e
+	}
+	routing, e := s.parseRouting(q.Get("routing"), q.Get("parent"))
+	if e != nil {
+		return 0, nil, e
+	}
+	var req object
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0, nil, parseError(err)
+	}
+	status, reply, e := s.updateDoc(name, typ, id, req, p)
+	if e != nil {
+		return 0, nil, e
+	}
+	if status == http.StatusCreated {
+		s.lookup(name, typ, id).routing = routing
+	}
+	if q.Get("_source") == "true" || q.Get("fields") == "_source" {
+		if d := s.lookup(name, typ, id); d != nil {
+			reply["get"] = object{"found": true, "_source": d.source}
+		}
+	}
+	return status, reply, nil
+}
+
+// parseScript returns the source and the parameters of the script of an
+// update request, an empty source if there is none. Scripts are top level
+// fields in ES 1.x, and objects since ES 2.x.
+func parseScript(req object) (string, object, *esError) {
+	switch script := req["script"].(type) {
+	case nil:
+		return "", nil, nil
+	case string:
+		params, _ := req["params"].(object)
+		return script, params, nil
+	case object:
+		params, _ := script["params"].(object)
+		for _, key := range []string{"source", "inline"} {
+			if source, ok := script[key].(string); ok {
+				return source, params, nil
+			}
+		}
+	}
+	return "", nil, badRequest("goosetest only supports inline scripts")
+}
+
+// checkRefresh checks the refresh parameter of a write. Documents are
+// always visible at once, so the refresh policy is not emulated.
+func (s *Server) checkRefresh(refresh string) *esError {
+	switch {
+	case refresh == "", refresh == "true", refresh == "false":
+		return nil
+	case refresh == "wait_for" && s.major >= 5:
+		return nil
+	}
+	return badRequest("unknown value for refresh: [%s]", refresh)
+}
+
+// parseRouting returns the routing value of a request, the parent id if
+// there is none. Like ES, it rejects parent ids in ES 7.x and later.
+func (s *Server) parseRouting(routing, parent string) (string, *esError) {
+	if parent != "" && s.major >= 7 {
+		return "", badRequest("request contains unrecognized parameter: [parent]")
+	}
+	if routing == "" {
+		routing = parent
+	}
+	return routing, nil
+}
+
+// precondition is the version of a document a write is conditioned on.
+type precondition struct {
+	version     int64  // internal version, 0 if none
+	seqNo       *int64 // if_seq_no
+	primaryTerm int64  // if_primary_term
+}
+
+// parsePrecondition returns the precondition given by the version, or the
+// if_seq_no and if_primary_term parameters, nil if there is none. Like ES,
+// it rejects versions in ES 7.x and later, and sequence numbers before
+// ES 6.x.
+func (s *Server) parsePrecondition(q url.Values) (*precondition, *esError) {
+	p := new(precondition)
+	if v := q.Get("version"); v != "" {
+		if s.major >= 7 {
+			return nil, &esError{400, "ActionRequestValidationException", "action_request_validation_exception",
+				"Validation Failed: 1: internal versioning can not be used for optimistic concurrency control. " +
+					"Please use `if_seq_no` and `if_primary_term` instead;", ""}
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, badRequest("invalid version %q", v)
+		}
+		p.version = n
+		return p, nil
+	}
+	seqNo, term := q.Get("if_seq_no"), q.Get("if_primary_term")
+	if seqNo == "" && term == "" {
+		return nil, nil
+	}
+	if s.major < 6 {
+		return nil, badRequest("request contains unrecognized parameters: [if_seq_no], [if_primary_term]")
+	}
+	n, err1 := strconv.ParseInt(seqNo, 10, 64)
+	t, err2 := strconv.ParseInt(term, 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, badRequest("invalid if_seq_no %q or if_primary_term %q", seqNo, term)
+	}
+	p.seqNo, p.primaryTerm = &n, t
+	return p, nil
+}
+
+// checkPrecondition returns a version conflict error if the document id,
+// d or nil if it does not exist, does not match the precondition p.
+func (s *Server) checkPrecondition(name, typ, id string, d *document, p *precondition) *esError {
+	if p == nil {
+		return nil
+	}
+	var reason string
+	switch {
+	case d == nil:
+		reason = "document does not exist"
+	case p.seqNo != nil && (d.seqNo != *p.seqNo || p.primaryTerm != 1):
+		reason = fmt.Sprintf("required seqNo [%d], primary term [%d]. current document has seqNo [%d] and primary term [1]",
+			*p.seqNo, p.primaryTerm, d.seqNo)
+	case p.seqNo == nil && d.version != p.version:
+		reason = fmt.Sprintf("current version [%d] is different than the one provided [%d]", d.version, p.version)
+	default:
+		return nil
+	}
+	return &esError{409, "VersionConflictEngineException", "version_conflict_engine_exception",
+		fmt.Sprintf("[%s][%s]: version conflict, %s", typ, id, reason), name}
+}
+
+// indexDoc stores src as the document id. It fails if the document exists
+// and create is set, or if it does not match the precondition p.
+func (s *Server) indexDoc(name, typ, id string, src []byte, create bool, p *precondition) (int, object, *esError) {
+	if !json.Valid(src) || !bytes.HasPrefix(bytes.TrimSpace(src), []byte("{")) {
+		return 0, nil, &esError{400, "MapperParsingException", "mapper_parsing_exception", "failed to parse", name}
+	}
+	idx, e := s.openIndex(s.createIndex(name))
+	if e != nil {
+		return 0, nil, e
+	}
+	docs := idx.docs[typ]
+	if docs == nil {
+		docs = make(map[string]*document)
+		idx.docs[typ] = docs
+	}
+	d, exists := docs[id]
+	if exists && create {
+		return 0, nil, s.alreadyExists(name, typ, id, d)
+	}
+	if e := s.checkPrecondition(name, typ, id, d, p); e != nil {
+		return 0, nil, e
+	}
+	if !exists {
+		s.seq++
+		d = &document{seq: s.seq}
+		docs[id] = d
+	}
+	d.source = append(json.RawMessage(nil), src...)
+	d.version++
+	d.seqNo = idx.seqNo
+	idx.seqNo++
+	status, result := http.StatusOK, "updated"
+	if !exists {
+		status, result = http.StatusCreated, "created"
+	}
+	return status, s.writeResult(name, typ, id, d, result), nil
+}
+
+// updateDoc applies the partial document or the upsert of req to the
+// document id, if it matches the precondition p.
+func (s *Server) updateDoc(name, typ, id string, req object, p *precondition) (int, object, *esError) {
+	script, params, e := parseScript(req)
+	if e != nil {
+		return 0, nil, e
+	}
+	doc, _ := req["doc"].(object)
+	idx, ok := s.indices[name]
+	var d *document
+	if ok {
+		if idx.closed {
+			return 0, nil, indexClosed(name)
+		}
+		d = idx.docs[typ][id]
+	}
+	if d == nil {
+		upsert, ok := req["upsert"].(object)
+		if asUpsert, _ := req["doc_as_upsert"].(bool); asUpsert && doc != nil {
+			upsert, ok = doc, true
+		}
+		if !ok {
+			return 0, nil, &esError{404, "DocumentMissingException", "document_missing_exception",
+				fmt.Sprintf("[%s][%s]: document missing", typ, id), name}
+		}
+		b, _ := json.Marshal(upsert)
+		return s.indexDoc(name, typ, id, b, false, nil)
+	}
+	if e := s.checkPrecondition(name, typ, id, d, p); e != nil {
+		return 0, nil, e
+	}
+	var src object
+	json.Unmarshal(d.source, &src)
+	orig := mustMarshal(src)
+	updated := src
+	if script != "" {
+		if err := dsl.RunScript(script, params, src); err != nil {
+			return 0, nil, &esError{400, "ElasticsearchIllegalArgumentException", "illegal_argument_exception",
+				"failed to execute script: " + err.Error(), name}
+		}
+	} else {
+		updated = dsl.Merge(src, doc)
+	}
+	b, _ := json.Marshal(updated)
+	if bytes.Equal(b, orig) && s.major >= 5 {
+		return http.StatusOK, s.writeResult(name, typ, id, d, "noop"), nil
+	}
+	return s.indexDoc(name, typ, id, b, false, nil)
+}
+
+func (s *Server) getDoc(name, typ, id string) (int, object, *esError) {
+	idx, e := s.openIndex(name)
+	if e != nil {
+		return 0, nil, e
+	}
+	reply := s.docMeta(name, typ, id)
+	d := idx.docs[typ][id]
+	if d == nil {
+		reply["found"] = false
+		return http.StatusNotFound, reply, nil
+	}
+	reply["found"] = true
+	reply["_version"] = d.version
+	reply["_source"] = d.source
+	if d.routing != "" {
+		reply["_routing"] = d.routing
+	}
+	if s.major >= 6 {
+		reply["_seq_no"] = d.seqNo
+		reply["_primary_term"] = 1
+	}
+	return http.StatusOK, reply, nil
+}
+
+// handleMget gets the documents listed in the body of a _mget request, from
+// the index name and the type typ unless the documents give theirs.
+func (s *Server) handleMget(name, typ string, body []byte) (int, interface{}, *esError) {
+	var req struct {
+		Docs []bulkMeta `json:"docs"`
+		Ids  []string   `json:"ids"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0, nil, parseError(err)
+	}
+	for _, id := range req.Ids {
+		req.Docs = append(req.Docs, bulkMeta{Id: id})
+	}
+	if len(req.Docs) == 0 {
+		return 0, nil, badRequest("no documents to get")
+	}
+	docs := make([]object, 0, len(req.Docs))
+	for _, meta := range req.Docs {
+		if meta.Index == "" {
+			meta.Index = name
+		}
+		if meta.Type == "" {
+			meta.Type = typ
+		}
+		if meta.Type == "" {
+			meta.Type = defaultType
+		}
+		_, doc, e := s.getDoc(meta.Index, meta.Type, meta.Id)
+		if e != nil {
+			doc = s.docMeta(meta.Index, meta.Type, meta.Id)
+			doc["error"] = s.errorBody(e)
+		}
+		docs = append(docs, doc)
+	}
+	return http.StatusOK, object{"docs": docs}, nil
+}
+
+func (s *Server) deleteDoc(name, typ, id string, p *precondition) (int, object, *esError) {
+	idx, e := s.openIndex(name)
+	if e != nil {
+		return 0, nil, e
+	}
+	d := idx.docs[typ][id]
+	if e := s.checkPrecondition(name, typ, id, d, p); e != nil {
+		return 0, nil, e
+	}
+	if d == nil {
+		reply := s.writeResult(name, typ, id, &document{version: 1}, "not_found")
+		reply["found"] = false
+		return http.StatusNotFound, reply, nil
+	}
+	delete(idx.docs[typ], id)
+	d.version++
+	d.seqNo = idx.seqNo
+	idx.seqNo++
+	reply := s.writeResult(name, typ, id, d, "deleted")
+	reply["found"] = true
+	return http.StatusOK, reply, nil
+}
+
+// docMeta returns the metadata of a document in replies.
+func (s *Server) docMeta(name, typ, id string) object {
+	meta := object{"_index": name, "_id": id}
+	if s.major < 8 {
+		meta["_type"] = typ
+	}
+	return meta
+}
+
+// writeResult returns the reply to a write of d.
+func (s *Server) writeResult(name, typ, id string, d *document, result string) object {
+	reply := s.docMeta(name, typ, id)
+	reply["_version"] = d.version
+	if s.major < 5 {
+		if result == "created" || result == "updated" {
+			reply["created"] = result == "created"
+		}
+		return reply
+	}
+	reply["result"] = result
+	reply["_shards"] = object{"total": 1, "successful": 1, "failed": 0}
+	if s.major >= 6 {
+		reply["_seq_no"] = d.seqNo
+		reply["_primary_term"] = 1
+	}
+	return reply
+}
+
+func (s *Server) alreadyExists(name, typ, id string, d *document) *esError {
+	reason := fmt.Sprintf("[%s][%s]: version conflict, document already exists (current version [%d])", typ, id, d.version)
+	if s.major < 5 {
+		return &esError{409, "DocumentAlreadyExistsException", "document_already_exists_exception", reason, name}
+	}
+	return &esError{409, "VersionConflictEngineException", "version_conflict_engine_exception", reason, name}
+}
+
+// createIndex creates the index name if it does not exist, and returns its
+// name.
+func (s *Server) createIndex(name string) string {
+	if _, ok := s.indices[name]; !ok {
+		s.indices[name] = &index{
+			mappings: make(map[string]json.RawMessage),
+			docs:     make(map[string]map[string]*document),
+		}
+	}
+	return name
+}
+
+// checkIndex returns an error if the index name does not exist.
+func (s *Server) checkIndex(name string) *esError {
+	if _, ok := s.indices[name]; !ok {
+		return indexMissing(name)
+	}
+	return nil
+}
+
+// openIndex returns the index name, which must exist and be open.
+func (s *Server) openIndex(name string) (*index, *esError) {
+	idx, ok := s.indices[name]
+	if !ok {
+		return nil, indexMissing(name)
+	}
+	if idx.closed {
+		return nil, indexClosed(name)
+	}
+	return idx, nil
+}
+
+// lookup returns the document id of type typ, or of any type if typ is
+// empty, in the index name.
+func (s *Server) lookup(name, typ, id string) *document {
+	idx, ok := s.indices[name]
+	if !ok {
+		return nil
+	}
+	if typ != "" {
+		return idx.docs[typ][id]
+	}
+	for _, docs := range idx.docs {
+		if d, ok := docs[id]; ok {
+			return d
+		}
+	}
+	return nil
+}
+
+func (s *Server) write(w http.ResponseWriter, status int, reply interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(reply)
+}
+
+// esError is an error replied by the server.
+type esError struct {
+	status int
+	legacy string // name of the error in ES 1.x
+	typ    string // type of the error since ES 2.x
+	reason string
+	index  string
+}
+
+func (e *esError) Error() string {
+	return e.typ + ": " + e.reason
+}
+
+// errorBody returns the error as replied by the server, including in bulk
+// replies.
+func (s *Server) errorBody(e *esError) interface{} {
+	if s.major < 2 {
+		return e.legacy + "[" + e.reason + "]"
+	}
+	cause := object{"type": e.typ, "reason": e.reason}
+	if e.index != "" {
+		cause["index"] = e.index
+	}
+	err := object{"root_cause": []object{cause}}
+	for k, v := range cause {
+		err[k] = v
+	}
+	return err
+}
+
+func (s *Server) writeError(w http.ResponseWriter, e *esError) {
+	s.write(w, e.status, object{"error": s.errorBody(e), "status": e.status})
+}
+
+func indexMissing(name string) *esError {
+	return &esError{404, "IndexMissingException", "index_not_found_exception", "no such index [" + name + "]", name}
+}
+
+func indexClosed(name string) *esError {
+	return &esError{403, "IndexClosedException", "index_closed_exception", "closed [" + name + "]", name}
+}
+
+func badRequest(format string, args ...interface{}) *esError {
+	return &esError{400, "ElasticsearchIllegalArgumentException", "illegal_argument_exception", fmt.Sprintf(format, args...), ""}
+}
+
+func parseError(err error) *esError {
+	return &esError{400, "ElasticsearchParseException", "parse_exception", err.Error(), ""}
+}
+
+func mustMarshal(v interface{}) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+// generateId returns a random document id like those generated by ES.
+func generateId() string {
+	b := make([]byte, 15)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
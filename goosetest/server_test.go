@@ -0,0 +1,322 @@
+package goosetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gotsunami/goose"
+)
+
+type shop struct {
+	Id       int            `json:"id"`
+	Name     string         `json:"name"`
+	Category string         `json:"category"`
+	Rating   float64        `json:"rating"`
+	Location goose.Location `json:"location"`
+}
+
+func (s *shop) Key() string {
+	return fmt.Sprintf("%d", s.Id)
+}
+
+func (s *shop) BuildPath() string {
+	return "shop/"
+}
+
+var shops = []*shop{
+	{1, "Blue Watch Store", "watches", 4.5, goose.Location{Lat: 43.61, Long: 3.87}},  // Montpellier
+	{2, "Red Shoes", "shoes", 3.2, goose.Location{Lat: 43.30, Long: 5.37}},           // Marseille
+	{3, "Watch Repair Shop", "watches", 4.9, goose.Location{Lat: 48.85, Long: 2.35}}, // Paris
+}
+
+func newClient(t *testing.T, s *Server) *goose.ElasticSearch {
+	es, err := goose.NewElasticSearch(s.IndexURL("shops"))
+	if err != nil {
+		t.Fatal("Cannot create client:", err)
+	}
+	return es
+}
+
+func TestCrud(t *testing.T) {
+	for _, version := range []string{"1.7.5", "2.4.6", "6.8.0", DefaultVersion, "8.11.1"} {
+		s := NewServer(WithVersion(version))
+		es := newClient(t, s)
+		typ := "shop"
+		if version[0] == '8' {
+			typ = "_doc"
+		}
+		s.AssertIndex(t, "shops")
+
+		if err := es.Insert(shops[0]); err != nil {
+			t.Fatalf("%s: Cannot insert: %v", version, err)
+		}
+		s.AssertDocument(t, "shops", typ, "1", shops[0])
+
+		got := &shop{Id: 1}
+		if found, err := es.Get(got); !found || err != nil || *got != *shops[0] {
+			t.Errorf("%s: wrong document %+v (%v)", version, got, err)
+		}
+		if found, err := es.Get(&shop{Id: 42}); found || !goose.IsNotFound(err) {
+			t.Errorf("%s: expected a not found error, got %v", version, err)
+		}
+
+		updated := *shops[0]
+		updated.Rating = 5
+		if err := es.Update(&updated); err != nil {
+			t.Errorf("%s: Cannot update: %v", version, err)
+		}
+		s.AssertDocument(t, "shops", typ, "1", &updated)
+		if err := es.Update(&shop{Id: 42}); !goose.IsNotFound(err) {
+			t.Errorf("%s: expected a document missing error, got %v", version, err)
+		}
+
+		if err := es.Delete(&updated); err != nil {
+			t.Errorf("%s: Cannot delete: %v", version, err)
+		}
+		s.AssertNoDocument(t, "shops", typ, "1")
+
+		objects := make([]goose.ElasticObject, len(shops))
+		for i, sh := range shops {
+			objects[i] = sh
+		}
+		if err := es.BulkInsert(objects); err != nil {
+			t.Errorf("%s: Cannot bulk insert: %v", version, err)
+		}
+		if n, err := es.Count(&shop{}); n != 3 || err != nil {
+			t.Errorf("%s: wrong count %d (%v)", version, n, err)
+		}
+
+		qb := goose.NewQueryBuilder().SetTerm("category", "shoes")
+		if _, err := es.DeleteByQuery(&shop{}, qb); err != nil {
+			t.Errorf("%s: Cannot delete by query: %v", version, err)
+		}
+		if n := s.Count("shops", ""); n != 2 {
+			t.Errorf("%s: expected 2 documents left, got %d", version, n)
+		}
+
+		if err := es.DeleteIndex(); err != nil {
+			t.Errorf("%s: Cannot delete index: %v", version, err)
+		}
+		s.AssertNoIndex(t, "shops")
+		if _, err := es.Get(got); !goose.IsIndexMissing(err) {
+			t.Errorf("%s: expected an index missing error, got %v", version, err)
+		}
+		s.Close()
+	}
+}
+
+func TestSearch(t *testing.T) {
+	for _, version := range []string{"1.7.5", DefaultVersion} {
+		s := NewServer(WithVersion(version))
+		es := newClient(t, s)
+		for _, sh := range shops {
+			if err := es.Insert(sh); err != nil {
+				t.Fatal("Cannot insert:", err)
+			}
+		}
+
+		montpellier := goose.Location{Lat: 43.61, Long: 3.88}
+		tests := []struct {
+			name   string
+			qb     *goose.QueryBuilder
+			should []int
+		}{
+			{"all", nil, []int{1, 2, 3}},
+			{"term", goose.NewQueryBuilder().SetTerm("category", "watches"), []int{1, 3}},
+			{"query string", goose.NewQueryBuilder().AddQueryString("name", "watch"), []int{1, 3}},
+			{"fuzzy", goose.NewQueryBuilder().AddFuzzySearch("name", "red shoes"), []int{2}},
+			{"range", goose.NewQueryBuilder().AddFloatRange("rating", 4, 4.8), []int{1}},
+			{"int range", goose.NewQueryBuilder().AddRange("id", 2, 3), []int{2, 3}},
+			{"distance", goose.NewQueryBuilder().AddGeoDistance("location", montpellier, 200, goose.KM), []int{1, 2}},
+			{"bounding box", goose.NewQueryBuilder().AddGeoBoundingBox("location",
+				goose.Location{Lat: 50, Long: 0}, goose.Location{Lat: 43.5, Long: 4}), []int{1, 3}},
+			{"sort", goose.NewQueryBuilder().AddSort("rating", goose.ORDER_DESC, goose.MODE_DEF), []int{3, 1, 2}},
+		}
+		for _, test := range tests {
+			rset, err := es.Search(&shop{}, test.qb)
+			if err != nil {
+				t.Errorf("%s: %s search fails: %v", version, test.name, err)
+				continue
+			}
+			var ids []int
+			for _, hit := range rset.Hits.Data {
+				ids = append(ids, hit.Object.(*shop).Id)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(test.should) || rset.Hits.Total != len(test.should) {
+				t.Errorf("%s: wrong %s results. Expected %v, got %v (total %d)", version, test.name, test.should, ids, rset.Hits.Total)
+			}
+		}
+
+		qb := goose.NewQueryBuilder().SetTermFacet("categories", "category", 1, nil)
+		rset, err := es.SearchCount(&shop{}, qb)
+		if err != nil {
+			t.Fatalf("%s: facet search fails: %v", version, err)
+		}
+		facet := rset.Facets["categories"]
+		if len(rset.Hits.Data) != 0 || rset.Hits.Total != 3 || facet.Total != 3 || len(facet.Terms) != 1 ||
+			facet.Terms[0]["term"] != "watches" || fmt.Sprint(facet.Terms[0]["count"]) != "2" {
+			t.Errorf("%s: wrong facet results %+v", version, rset)
+		}
+
+		if _, err := es.SearchRawJSON(&shop{}, `{"query":{"fuzzy_like_this":{}}}`); err == nil {
+			t.Errorf("%s: unsupported query accepted", version)
+		}
+		s.Close()
+	}
+}
+
+func TestMappings(t *testing.T) {
+	s := NewServer(WithVersion("1.7.5"))
+	defer s.Close()
+	es := newClient(t, s)
+	mb := goose.NewMappingBuilder().AddMapping("location", goose.TYPE_GEOPOINT)
+	if err := es.SetMapping(&shop{}, mb); err != nil {
+		t.Fatal("Cannot set mapping:", err)
+	}
+	s.AssertRequested(t, "PUT", "/shops/shop/_mappings")
+	m, err := es.GetMapping(&shop{})
+	if err != nil || m != `{"shops":{"mappings":{"shop":{"properties":{"location":{"type":"geo_point"}}}}}}`+"\n" {
+		t.Errorf("wrong mapping %s (%v)", m, err)
+	}
+	if _, err := es.DeleteMappingAndData(&shop{}); err != nil {
+		t.Error("Cannot delete mapping:", err)
+	}
+}
+
+func TestOpenAndClose(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	es := newClient(t, s)
+	if err := es.CloseIndex(); err != nil {
+		t.Fatal("Cannot close index:", err)
+	}
+	if err := es.Insert(shops[0]); err == nil {
+		t.Error("document inserted in a closed index")
+	}
+	if err := es.OpenIndex(); err != nil {
+		t.Fatal("Cannot open index:", err)
+	}
+	if err := es.Insert(shops[0]); err != nil {
+		t.Error("Cannot insert:", err)
+	}
+	if err := es.CreateIndex(); err == nil {
+		t.Error("existing index created again")
+	}
+}
+
+func TestRecording(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	es := newClient(t, s)
+	s.AssertRequested(t, "GET", "/")
+	s.AssertRequested(t, "PUT", "/shops")
+	s.AssertRequestCount(t, "", "/shops/_stats", 1)
+
+	s.ClearRequests()
+	if err := es.Insert(shops[0]); err != nil {
+		t.Fatal("Cannot insert:", err)
+	}
+	s.AssertRequested(t, "PUT", "/shops/shop/1")
+	s.AssertNotRequested(t, "GET", "/")
+	if r := s.Requests(); len(r) != 1 || string(r[0].Body) != `{"id":1,"name":"Blue Watch Store","category":"watches","rating":4.5,"location":{"lat":43.61,"lon":3.87}}` {
+		t.Errorf("wrong recorded requests %v", r)
+	}
+
+	s.ClearRequests()
+	es.SearchCount(&shop{}, nil)
+	s.AssertRequested(t, "GET", "/shops/shop/_search?size=0")
+
+	s.FailNext(http.StatusServiceUnavailable)
+	if err := es.Insert(shops[1]); err == nil {
+		t.Error("injected failure not returned")
+	}
+	s.AssertNoDocument(t, "shops", "", "2")
+	if err := es.Insert(shops[1]); err != nil {
+		t.Error("failures injected beyond the next request:", err)
+	}
+	s.AssertDocument(t, "shops", "", "2", shops[1])
+}
+
+func TestSeeding(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	if err := s.Put("shops", "shop", "1", shops[0]); err != nil {
+		t.Fatal("Cannot put document:", err)
+	}
+	es, _ := goose.NewElasticSearch(s.IndexURL("shops"), goose.WithIndexCreation(false))
+	got := &shop{Id: 1}
+	if found, err := es.Get(got); !found || err != nil || *got != *shops[0] {
+		t.Errorf("wrong seeded document %+v (%v)", got, err)
+	}
+	if fmt.Sprint(s.Indices()) != "[shops]" {
+		t.Errorf("wrong indices %v", s.Indices())
+	}
+}
+
+func TestRouting(t *testing.T) {
+	for _, version := range []string{"1.7.5", DefaultVersion} {
+		s := NewServer(WithVersion(version))
+		req, _ := http.NewRequest("PUT", s.URL+"/shops/shop/1?parent=p1", strings.NewReader(`{"name":"a"}`))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		should := http.StatusBadRequest
+		if version == "1.7.5" {
+			should = http.StatusCreated
+		}
+		if resp.StatusCode != should {
+			t.Errorf("%s: expected a %d reply to a parent parameter, got %d", version, should, resp.StatusCode)
+		}
+		http.Post(s.URL+"/shops/shop/2?routing=r2", "application/json", strings.NewReader(`{"name":"b"}`))
+		for id, routing := range map[string]string{"1": "p1", "2": "r2"} {
+			resp, err := http.Get(s.URL + "/shops/shop/" + id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var reply struct {
+				Routing string `json:"_routing"`
+			}
+			json.NewDecoder(resp.Body).Decode(&reply)
+			resp.Body.Close()
+			if reply.Routing != routing && (id == "2" || version == "1.7.5") {
+				t.Errorf("%s: expected the routing %q of %s, got %q", version, routing, id, reply.Routing)
+			}
+		}
+		s.Close()
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	for _, version := range []string{"1.7.5", DefaultVersion} {
+		s := NewServer(WithVersion(version))
+		s.CreateIndex("shops")
+		resp, err := http.Post(s.URL+"/shops/shop/1?refresh=wait_for", "application/json", strings.NewReader(`{"name":"a"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		should := http.StatusCreated
+		if version == "1.7.5" {
+			should = http.StatusBadRequest
+		}
+		if resp.StatusCode != should {
+			t.Errorf("%s: expected a %d reply to wait_for, got %d", version, should, resp.StatusCode)
+		}
+		for name, status := range map[string]int{"shops": http.StatusOK, "missing": http.StatusNotFound} {
+			resp, err := http.Post(s.URL+"/"+name+"/_refresh", "application/json", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != status {
+				t.Errorf("%s: expected a %d reply to the refresh of %s, got %d", version, status, name, resp.StatusCode)
+			}
+		}
+		s.Close()
+	}
+}
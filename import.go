@@ -0,0 +1,216 @@
+package goose
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// ImportConfig defines the format of the NDJSON stream read by Import and
+// how it is split into bulk requests. Zero values select the defaults.
+type ImportConfig struct {
+	// Actions tells that the stream holds action and source line pairs, in
+	// the format of the bulk API, instead of one document per line.
+	Actions bool
+	// IdField is the field of the documents holding their id. ES generates
+	// the ids if empty. Not used with Actions.
+	IdField string
+
+	MaxActions  int           // Actions per bulk request, 1000 by default
+	MaxBytes    int           // Approximate size of the bulk requests, 5MB by default
+	MaxFailures int           // Failures detailed in the summary, 100 by default
+	Refresh     RefreshPolicy // Of the bulk requests, the default one of the instance if empty
+}
+
+// ImportSummary is the outcome of an Import.
+type ImportSummary struct {
+	Indexed  int             // Successful actions
+	Failed   int             // Failed actions and invalid lines
+	Failures []ImportFailure // The first failures, by line
+}
+
+// ImportFailure is a line of an imported stream which could not be
+// imported.
+type ImportFailure struct {
+	Line int // from 1; the action line with Actions
+	Err  error
+}
+
+// importLine is an action of a bulk request being imported.
+type importLine struct {
+	line int
+	op   string
+}
+
+// imports the NDJSON stream r into the index with bulk requests, the
+// documents being stored as objects of the type of object. The stream is
+// read as it is sent, it is never held in memory as a whole. Documents
+// failing to be imported do not stop the import: they are reported by the
+// summary. The error is only set if the stream cannot be read, has invalid
+// action lines, or if a bulk request failed as a whole.
+//
+// For example, to import a dump of HQ documents whose ids are in their
+// "key" field:
+//
+//	summary, err := es.Import(f, &HQ{}, ImportConfig{IdField: "key"})
+func (se *ElasticSearch) Import(r io.Reader, object ElasticObject, cfg ImportConfig) (*ImportSummary, error) {
+	return se.ImportContext(context.Background(), r, object, cfg)
+}
+
+// ImportContext is like Import but uses ctx to bound the requests.
+func (se *ElasticSearch) ImportContext(ctx context.Context, r io.Reader, object ElasticObject, cfg ImportConfig) (*ImportSummary, error) {
+	if cfg.MaxActions < 1 {
+		cfg.MaxActions = defaultBulkActions
+	}
+	if cfg.MaxBytes < 1 {
+		cfg.MaxBytes = defaultBulkBytes
+	}
+	if cfg.MaxFailures < 1 {
+		cfg.MaxFailures = 100
+	}
+	v, err := se.VersionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	path, err := se.typePath(v.Dialect(), object)
+	if err != nil {
+		return nil, err
+	}
+	params := make(url.Values)
+	se.refreshParams(v, cfg.Refresh, params, true)
+	path = addQuery(path+actionBulk, params.Encode())
+	summary := new(ImportSummary)
+	var buf bytes.Buffer
+	var lines []importLine
+	flush := func() error {
+		if len(lines) == 0 {
+			return nil
+		}
+		err := se.importChunk(ctx, path, &buf, lines, summary, cfg.MaxFailures)
+		buf.Reset()
+		lines = lines[:0]
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	n := 0
+	// next returns the next non-empty line and its number, io.EOF at the end
+	next := func() ([]byte, int, error) {
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(line) > 0 {
+				n++
+				if line = bytes.TrimSpace(line); len(line) > 0 {
+					return line, n, nil
+				}
+			}
+			if err != nil {
+				return nil, n, err
+			}
+		}
+	}
+	for {
+		line, num, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return summary, err
+		}
+		var op string
+		var source []byte
+		if cfg.Actions {
+			var action map[string]json.RawMessage
+			if json.Unmarshal(line, &action) != nil || len(action) != 1 {
+				return summary, fmt.Errorf("invalid action at line %d", num)
+			}
+			for key := range action {
+				op = key
+			}
+			if op != BULK_DELETE {
+				if source, _, err = next(); err != nil {
+					return summary, fmt.Errorf("missing source of the action at line %d", num)
+				}
+			}
+		} else {
+			var doc map[string]json.RawMessage
+			if err := json.Unmarshal(line, &doc); err != nil {
+				summary.fail(num, err, cfg.MaxFailures)
+				continue
+			}
+			meta := M{}
+			if cfg.IdField != "" {
+				id, ok := doc[cfg.IdField]
+				if !ok {
+					summary.fail(num, fmt.Errorf("missing id field %s", cfg.IdField), cfg.MaxFailures)
+					continue
+				}
+				// string ids are unquoted, numbers kept as written
+				var s string
+				if json.Unmarshal(id, &s) != nil {
+					s = string(id)
+				}
+				meta["_id"] = s
+			}
+			op, source = BULK_INDEX, line
+			if line, err = json.Marshal(M{op: meta}); err != nil {
+				return summary, err
+			}
+		}
+		for _, l := range [][]byte{line, source} {
+			if l != nil {
+				buf.Write(l)
+				buf.WriteByte('\n')
+			}
+		}
+		lines = append(lines, importLine{num, op})
+		if len(lines) >= cfg.MaxActions || buf.Len() >= cfg.MaxBytes {
+			if err := flush(); err != nil {
+				return summary, err
+			}
+		}
+	}
+	return summary, flush()
+}
+
+// importChunk sends the bulk request body of the actions of lines, and
+// updates summary with its outcome.
+func (se *ElasticSearch) importChunk(ctx context.Context, path string, body io.Reader, lines []importLine, summary *ImportSummary, maxFailures int) error {
+	resp, err := se.sendRequestAndGetResponse(ctx, "Import", POST, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Items []map[string]bulkItemReply `json:"items"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return err
+	}
+	if len(res.Items) != len(lines) {
+		return errors.New("unexpected number of items in bulk reply")
+	}
+	for i, l := range lines {
+		reply := res.Items[i][l.op]
+		if item := reply.item(bulkAction{op: l.op}); item.Err != nil {
+			summary.fail(l.line, item.Err, maxFailures)
+		} else {
+			summary.Indexed++
+		}
+	}
+	return nil
+}
+
+// fail records the failure of line, detailed if there are less than max
+// failures.
+func (s *ImportSummary) fail(line int, err error, max int) {
+	s.Failed++
+	if len(s.Failures) < max {
+		s.Failures = append(s.Failures, ImportFailure{line, err})
+	}
+}
@@ -0,0 +1,66 @@
+package goose
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gotsunami/goose/goosetest"
+)
+
+const importedDocs = `{"id":1,"description":"one"}
+not json
+
+{"id":2,"description":"two"}
+{"description":"no id"}
+{"id":3,"description":"three"}`
+
+const importedActions = `{"index":{"_id":"10"}}
+{"id":10,"description":"ten"}
+{"delete":{"_id":"1"}}
+{"create":{"_id":"2"}}
+{"id":2}
+`
+
+func TestImport(t *testing.T) {
+	forEachEngine(t, []string{"1.7.5", "7.10.2", "8.11.1"}, func(t *testing.T, _ *goosetest.Server, engine SearchEngine) {
+		es := engine.(*ElasticSearch)
+		summary, err := es.Import(strings.NewReader(importedDocs), &DummyObject{}, ImportConfig{IdField: "id", MaxActions: 2})
+		if err != nil {
+			t.Fatalf("Cannot import: %v", err)
+		}
+		var lines []int
+		for _, f := range summary.Failures {
+			lines = append(lines, f.Line)
+		}
+		if summary.Indexed != 3 || summary.Failed != 2 || !reflect.DeepEqual(lines, []int{2, 5}) {
+			t.Errorf("expected 3 documents indexed and lines 2 and 5 failed, got %+v", summary)
+		}
+		dummy := &DummyObject{Id: 3}
+		if found, err := es.Get(dummy); !found || dummy.Description != "three" {
+			t.Errorf("imported document not found (%v)", err)
+		}
+
+		summary, err = es.Import(strings.NewReader(importedActions), &DummyObject{}, ImportConfig{Actions: true})
+		if err != nil {
+			t.Fatalf("Cannot import actions: %v", err)
+		}
+		if summary.Indexed != 2 || summary.Failed != 1 || summary.Failures[0].Line != 4 || !IsConflict(summary.Failures[0].Err) {
+			t.Errorf("expected a conflict at line 4, got %+v", summary)
+		}
+		if found, _ := es.Get(&DummyObject{Id: 10}); !found {
+			t.Error("imported document 10 not found")
+		}
+		if found, _ := es.Get(&DummyObject{Id: 1}); found {
+			t.Error("document 1 not deleted")
+		}
+
+		// generated ids
+		if summary, err = es.Import(strings.NewReader(`{"id":4}`), &DummyObject{}, ImportConfig{}); err != nil || summary.Indexed != 1 {
+			t.Errorf("expected a document indexed, got %+v (%v)", summary, err)
+		}
+		if _, err = es.Import(strings.NewReader("{\"index\":{}}\n{}\nnot an action"), &DummyObject{}, ImportConfig{Actions: true}); err == nil {
+			t.Error("expected an error on invalid actions")
+		}
+	})
+}
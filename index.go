@@ -1,18 +1,56 @@
 package goose
 
 import (
+	"context"
 	"net/http"
+	"sort"
+	"strings"
 )
 
 // creates an index. Before using an index, it is mandatory to send a XPUT request
 func (se *ElasticSearch) CreateIndex() error {
-	return se.sendRequest(PUT, se.serverUrl+se.basePath, nil)
+	return se.CreateIndexContext(context.Background())
+}
+
+// CreateIndexContext is like CreateIndex but uses ctx to bound the request.
+func (se *ElasticSearch) CreateIndexContext(ctx context.Context) error {
+	return se.sendRequest(ctx, "CreateIndex", PUT, se.basePath, nil)
+}
+
+// refreshes the index, making the changes made so far visible to searches.
+// The indices given by the resolver of WithIndexResolver so far are
+// refreshed too.
+func (se *ElasticSearch) Refresh() error {
+	return se.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh but uses ctx to bound the request.
+func (se *ElasticSearch) RefreshContext(ctx context.Context) error {
+	path := se.basePath + actionRefresh
+	se.indexMu.Lock()
+	if len(se.typeIndices) > 0 {
+		names := []string{strings.Trim(se.basePath, "/")}
+		for name := range se.typeIndices {
+			if name != names[0] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names[1:])
+		// the indices are created with their first document
+		path = "/" + strings.Join(names, ",") + "/" + actionRefresh + "?ignore_unavailable=true"
+	}
+	se.indexMu.Unlock()
+	return se.sendRequest(ctx, "Refresh", POST, path, nil)
 }
 
 // use _stats command to check that the index exists
 func (se *ElasticSearch) IndexExists() (bool, error) {
-	resp, err := se.sendRequestAndGetResponse(GET, se.serverUrl+se.basePath+actionStats, nil)
-	defer resp.Body.Close()
+	return se.IndexExistsContext(context.Background())
+}
+
+// IndexExistsContext is like IndexExists but uses ctx to bound the request.
+func (se *ElasticSearch) IndexExistsContext(ctx context.Context) (bool, error) {
+	resp, err := se.sendRequestAndGetResponse(ctx, "IndexExists", GET, se.basePath+actionStats, nil)
 	// response is "IndexMissingException", so returns false and ignores error
 	if resp != nil && resp.StatusCode == http.StatusNotFound {
 		return false, nil
@@ -21,6 +59,7 @@ func (se *ElasticSearch) IndexExists() (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	resp.Body.Close()
 	// no error, index exists, returns true
 	return true, nil
 }
@@ -28,24 +67,45 @@ func (se *ElasticSearch) IndexExists() (bool, error) {
 // silently creates an index if it does not exist. Intents creation only if no error
 // was returned by ElasticSearch.IndexExists()
 func (se *ElasticSearch) CreateIndexIfNeeded() error {
-	exists, err := se.IndexExists()
+	return se.CreateIndexIfNeededContext(context.Background())
+}
+
+// CreateIndexIfNeededContext is like CreateIndexIfNeeded but uses ctx to
+// bound the requests.
+func (se *ElasticSearch) CreateIndexIfNeededContext(ctx context.Context) error {
+	exists, err := se.IndexExistsContext(ctx)
 	if exists == false && err == nil {
-		err = se.sendRequest(PUT, se.serverUrl+se.basePath, nil)
+		err = se.sendRequest(ctx, "CreateIndex", PUT, se.basePath, nil)
 	}
 	return err
 }
 
 // opens an index
 func (se *ElasticSearch) OpenIndex() error {
-	return se.sendRequest(POST, se.serverUrl+se.basePath+actionOpen, nil)
+	return se.OpenIndexContext(context.Background())
+}
+
+// OpenIndexContext is like OpenIndex but uses ctx to bound the request.
+func (se *ElasticSearch) OpenIndexContext(ctx context.Context) error {
+	return se.sendRequest(ctx, "OpenIndex", POST, se.basePath+actionOpen, nil)
 }
 
 // closes an index (necessary before calling actions like _settings or _mappings)
 func (se *ElasticSearch) CloseIndex() error {
-	return se.sendRequest(POST, se.serverUrl+se.basePath+actionClose, nil)
+	return se.CloseIndexContext(context.Background())
+}
+
+// CloseIndexContext is like CloseIndex but uses ctx to bound the request.
+func (se *ElasticSearch) CloseIndexContext(ctx context.Context) error {
+	return se.sendRequest(ctx, "CloseIndex", POST, se.basePath+actionClose, nil)
 }
 
 // deletes an index
 func (se *ElasticSearch) DeleteIndex() error {
-	return se.sendRequest(DELETE, se.serverUrl+se.basePath, nil)
+	return se.DeleteIndexContext(context.Background())
+}
+
+// DeleteIndexContext is like DeleteIndex but uses ctx to bound the request.
+func (se *ElasticSearch) DeleteIndexContext(ctx context.Context) error {
+	return se.sendRequest(ctx, "DeleteIndex", DELETE, se.basePath, nil)
 }
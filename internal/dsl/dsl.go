@@ -0,0 +1,815 @@
+// Package dsl evaluates the ES query DSL on JSON documents. It supports the
+// match_all, term, terms, match, match_phrase, query_string, range, exists,
+// ids, bool, filtered and geo clauses, sorting and terms facets or
+// aggregations.
+package dsl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type object = map[string]interface{}
+
+// Document is a document with its id and its decoded JSON source.
+type Document struct {
+	Id     string
+	Source map[string]interface{}
+}
+
+// Request is the body of a search request.
+type Request struct {
+	From   *int                       `json:"from"`
+	Size   *int                       `json:"size"`
+	Query  map[string]interface{}     `json:"query"`
+	Sort   []interface{}              `json:"sort"`
+	Facets map[string]json.RawMessage `json:"facets"`
+	Aggs   map[string]json.RawMessage `json:"aggs"`
+}
+
+// ParseRequest parses the body of a search request. An empty body matches
+// every document.
+func ParseRequest(body []byte) (*Request, error) {
+	req := new(Request)
+	if len(body) == 0 {
+		return req, nil
+	}
+	var raw struct {
+		Request
+		Aggregations map[string]json.RawMessage `json:"aggregations"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+	*req = raw.Request
+	if req.Aggs == nil {
+		req.Aggs = raw.Aggregations
+	}
+	return req, nil
+}
+
+// Merge returns the document src updated with the partial document doc, like
+// the _update API does: objects are merged recursively.
+func Merge(src, doc map[string]interface{}) map[string]interface{} {
+	merged := make(object, len(src)+len(doc))
+	for k, v := range src {
+		merged[k] = v
+	}
+	for k, v := range doc {
+		sub, ok1 := merged[k].(object)
+		dsub, ok2 := v.(object)
+		if ok1 && ok2 {
+			merged[k] = Merge(sub, dsub)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// Diff returns the partial document which updates the document old into the
+// document new when merged into it: the fields of new which differ from the
+// ones of old, objects being compared recursively, and null for the fields
+// missing from new.
+func Diff(old, new map[string]interface{}) map[string]interface{} {
+	diff := make(object)
+	for k, v := range new {
+		o, ok := old[k]
+		sub, ok1 := o.(object)
+		nsub, ok2 := v.(object)
+		switch {
+		case ok1 && ok2:
+			if d := Diff(sub, nsub); len(d) > 0 {
+				diff[k] = d
+			}
+		case !ok || !reflect.DeepEqual(o, v):
+			diff[k] = v
+		}
+	}
+	for k, v := range old {
+		if _, ok := new[k]; !ok && v != nil {
+			diff[k] = nil
+		}
+	}
+	return diff
+}
+
+// Match reports whether the document d matches the query clause q.
+func Match(q map[string]interface{}, d *Document) (bool, error) {
+	for kind, v := range q {
+		if v == nil {
+			continue
+		}
+		ok, err := matchClause(kind, v, d)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchClause reports whether the document d matches the clause kind with
+// parameters v.
+func matchClause(kind string, v interface{}, d *Document) (bool, error) {
+	params, _ := v.(object)
+	switch kind {
+	case "match_all":
+		return true, nil
+	case "filtered", "constant_score":
+		for _, key := range []string{"query", "filter"} {
+			if sub, ok := params[key].(object); ok {
+				if ok, err := Match(sub, d); err != nil || !ok {
+					return false, err
+				}
+			}
+		}
+		return true, nil
+	case "bool":
+		return matchBool(params, d)
+	case "ids":
+		values, _ := params["values"].([]interface{})
+		for _, id := range values {
+			if fmt.Sprint(id) == d.Id {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "query_string":
+		return matchQueryString(params, d)
+	}
+
+	// clauses on a field
+	var field string
+	var arg interface{}
+	for k, a := range params {
+		switch k {
+		case "distance", "distance_type", "unit", "boost", "_name", "validation_method":
+			continue
+		}
+		field, arg = k, a
+	}
+	if kind == "exists" {
+		field, _ = params["field"].(string)
+	}
+	if field == "" {
+		return false, fmt.Errorf("no field in %s clause", kind)
+	}
+	values := fieldValues(d.Source, field)
+	switch kind {
+	case "exists":
+		return len(values) > 0, nil
+	case "term":
+		if p, ok := arg.(object); ok {
+			arg = p["value"]
+		}
+		return anyValue(values, func(v interface{}) bool { return termMatches(v, arg) }), nil
+	case "terms":
+		terms, _ := arg.([]interface{})
+		return anyValue(values, func(v interface{}) bool {
+			for _, t := range terms {
+				if termMatches(v, t) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	case "match", "match_phrase":
+		query, operator, phrase := arg, "or", kind == "match_phrase"
+		if p, ok := arg.(object); ok {
+			query = p["query"]
+			if op, ok := p["operator"].(string); ok {
+				operator = strings.ToLower(op)
+			}
+			if t, _ := p["type"].(string); t == "phrase" {
+				phrase = true
+			}
+		}
+		text := fmt.Sprint(query)
+		if phrase {
+			return anyValue(values, func(v interface{}) bool { return containsPhrase(v, text) }), nil
+		}
+		return matchTokens(values, tokenize(text), operator == "and"), nil
+	case "range":
+		p, ok := arg.(object)
+		if !ok {
+			return false, fmt.Errorf("invalid range on %s", field)
+		}
+		return anyValue(values, func(v interface{}) bool { return inRange(v, p) }), nil
+	case "geo_distance":
+		center, ok := parsePoint(arg)
+		if !ok {
+			return false, fmt.Errorf("invalid geo_distance point on %s", field)
+		}
+		distance, err := parseDistance(fmt.Sprint(params["distance"]))
+		if err != nil {
+			return false, err
+		}
+		return anyPoint(d.Source, field, func(p point) bool { return haversine(center, p) <= distance }), nil
+	case "geo_bounding_box":
+		p, _ := arg.(object)
+		tl, ok1 := parsePoint(p["top_left"])
+		br, ok2 := parsePoint(p["bottom_right"])
+		if !ok1 || !ok2 {
+			return false, fmt.Errorf("invalid geo_bounding_box on %s", field)
+		}
+		return anyPoint(d.Source, field, func(p point) bool {
+			return p.lat <= tl.lat && p.lat >= br.lat && p.lon >= tl.lon && p.lon <= br.lon
+		}), nil
+	case "geo_polygon":
+		p, _ := arg.(object)
+		raw, _ := p["points"].([]interface{})
+		polygon := make([]point, 0, len(raw))
+		for _, r := range raw {
+			pt, ok := parsePoint(r)
+			if !ok {
+				return false, fmt.Errorf("invalid geo_polygon on %s", field)
+			}
+			polygon = append(polygon, pt)
+		}
+		return anyPoint(d.Source, field, func(p point) bool { return inPolygon(p, polygon) }), nil
+	}
+	return false, fmt.Errorf("goosetest does not support %s queries", kind)
+}
+
+// matchBool evaluates a bool query. Should clauses are optional if there are
+// must or filter clauses, unless minimum_should_match is set.
+func matchBool(params object, d *Document) (bool, error) {
+	for _, key := range []string{"must", "filter"} {
+		for _, c := range clauses(params[key]) {
+			if ok, err := Match(c, d); err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+	for _, c := range clauses(params["must_not"]) {
+		if ok, err := Match(c, d); err != nil || ok {
+			return false, err
+		}
+	}
+	should := clauses(params["should"])
+	min := 0
+	if len(clauses(params["must"]))+len(clauses(params["filter"])) == 0 && len(should) > 0 {
+		min = 1
+	}
+	if m, ok := params["minimum_should_match"]; ok {
+		n, err := strconv.Atoi(strings.TrimSuffix(fmt.Sprint(m), "%"))
+		if err != nil {
+			return false, fmt.Errorf("invalid minimum_should_match %v", m)
+		}
+		if strings.HasSuffix(fmt.Sprint(m), "%") {
+			n = n * len(should) / 100
+		}
+		min = n
+	}
+	matched := 0
+	for _, c := range should {
+		ok, err := Match(c, d)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched++
+		}
+	}
+	return matched >= min, nil
+}
+
+// clauses returns the clauses of a bool query, given as an object or an
+// array.
+func clauses(v interface{}) []object {
+	switch c := v.(type) {
+	case object:
+		return []object{c}
+	case []interface{}:
+		list := make([]object, 0, len(c))
+		for _, e := range c {
+			if o, ok := e.(object); ok {
+				list = append(list, o)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// matchQueryString evaluates a subset of the query string syntax: terms,
+// quoted phrases, trailing wildcards and field:term prefixes, combined with
+// the default operator.
+func matchQueryString(params object, d *Document) (bool, error) {
+	query, _ := params["query"].(string)
+	fields := []string{"_all"}
+	if f, ok := params["default_field"].(string); ok {
+		fields = []string{f}
+	}
+	if fs, ok := params["fields"].([]interface{}); ok {
+		fields = fields[:0]
+		for _, f := range fs {
+			fields = append(fields, fmt.Sprint(f))
+		}
+	}
+	and := strings.EqualFold(fmt.Sprint(params["default_operator"]), "and")
+
+	var parts []string
+	for i := 0; i < len(query); {
+		switch {
+		case query[i] == ' ':
+			i++
+		case query[i] == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				return false, fmt.Errorf("unterminated phrase in query string %q", query)
+			}
+			parts = append(parts, query[i:i+end+2])
+			i += end + 2
+		default:
+			end := strings.IndexByte(query[i:], ' ')
+			if end < 0 {
+				end = len(query) - i
+			}
+			parts = append(parts, query[i:i+end])
+			i += end
+		}
+	}
+
+	matched := 0
+	operands := 0
+	for _, part := range parts {
+		switch part {
+		case "AND":
+			and = true
+			continue
+		case "OR":
+			continue
+		}
+		operands++
+		partFields := fields
+		if i := strings.IndexByte(part, ':'); i > 0 && !strings.HasPrefix(part, `"`) {
+			partFields, part = []string{part[:i]}, part[i+1:]
+		}
+		ok := false
+		for _, f := range partFields {
+			values := allValues(d.Source, f)
+			switch {
+			case strings.HasPrefix(part, `"`):
+				phrase := strings.Trim(part, `"`)
+				ok = anyValue(values, func(v interface{}) bool { return containsPhrase(v, phrase) })
+			case strings.HasSuffix(part, "*"):
+				prefix := strings.ToLower(strings.TrimSuffix(part, "*"))
+				ok = anyValue(values, func(v interface{}) bool {
+					for _, t := range tokenize(fmt.Sprint(v)) {
+						if strings.HasPrefix(t, prefix) {
+							return true
+						}
+					}
+					return false
+				})
+			default:
+				ok = matchTokens(values, tokenize(part), true)
+			}
+			if ok {
+				break
+			}
+		}
+		if ok {
+			matched++
+		}
+	}
+	if and {
+		return matched == operands, nil
+	}
+	return matched > 0 || operands == 0, nil
+}
+
+// allValues returns the values of field, or of every field for _all.
+func allValues(src object, field string) []interface{} {
+	if field != "_all" && field != "*" {
+		return fieldValues(src, field)
+	}
+	var values []interface{}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch c := v.(type) {
+		case object:
+			for _, e := range c {
+				walk(e)
+			}
+		case []interface{}:
+			for _, e := range c {
+				walk(e)
+			}
+		default:
+			values = append(values, c)
+		}
+	}
+	walk(src)
+	return values
+}
+
+// fieldValues returns the values of the field with the dotted name field in
+// src. Arrays are flattened. Unknown sub-fields, like the multi-fields of a
+// field, resolve to the value of the field.
+func fieldValues(src object, field string) []interface{} {
+	var v interface{} = src
+	for _, name := range strings.Split(field, ".") {
+		o, ok := v.(object)
+		if !ok {
+			break // multi-field of a scalar field
+		}
+		if v, ok = o[name]; !ok {
+			return nil
+		}
+	}
+	if list, ok := v.([]interface{}); ok {
+		return list
+	}
+	if v == nil {
+		return nil
+	}
+	return []interface{}{v}
+}
+
+func anyValue(values []interface{}, f func(interface{}) bool) bool {
+	for _, v := range values {
+		if f(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// termMatches reports whether the value v of a field matches the term t,
+// either exactly or as one of its tokens.
+func termMatches(v, t interface{}) bool {
+	sv, st := format(v), format(t)
+	if sv == st {
+		return true
+	}
+	if _, ok := v.(string); ok {
+		for _, token := range tokenize(sv) {
+			if token == st {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchTokens reports whether the values contain any, or all, of tokens.
+func matchTokens(values []interface{}, tokens []string, all bool) bool {
+	found := make(map[string]bool, len(tokens))
+	for _, v := range values {
+		for _, t := range tokenize(format(v)) {
+			found[t] = true
+		}
+	}
+	n := 0
+	for _, t := range tokens {
+		if found[t] {
+			n++
+		}
+	}
+	if all {
+		return n == len(tokens) && n > 0
+	}
+	return n > 0
+}
+
+// containsPhrase reports whether the tokens of the phrase appear in sequence
+// in the value v.
+func containsPhrase(v interface{}, phrase string) bool {
+	tokens := tokenize(format(v))
+	want := tokenize(phrase)
+	if len(want) == 0 {
+		return false
+	}
+	for i := 0; i+len(want) <= len(tokens); i++ {
+		ok := true
+		for j, w := range want {
+			if tokens[i+j] != w {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenize splits s into lowercase words, like the standard analyzer.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// format returns the string representation of a JSON value.
+func format(v interface{}) string {
+	switch c := v.(type) {
+	case float64:
+		return strconv.FormatFloat(c, 'f', -1, 64)
+	case string:
+		return c
+	}
+	return fmt.Sprint(v)
+}
+
+// compare compares the JSON values a and b, numerically if both are numbers.
+func compare(a, b interface{}) int {
+	fa, erra := strconv.ParseFloat(format(a), 64)
+	fb, errb := strconv.ParseFloat(format(b), 64)
+	if erra == nil && errb == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(format(a), format(b))
+}
+
+// inRange reports whether v is within the bounds of a range clause.
+func inRange(v interface{}, p object) bool {
+	includeLower, includeUpper := true, true
+	if b, ok := p["include_lower"].(bool); ok {
+		includeLower = b
+	}
+	if b, ok := p["include_upper"].(bool); ok {
+		includeUpper = b
+	}
+	for k, bound := range p {
+		if bound == nil {
+			continue
+		}
+		c := compare(v, bound)
+		switch k {
+		case "gt":
+			if c <= 0 {
+				return false
+			}
+		case "gte":
+			if c < 0 {
+				return false
+			}
+		case "lt":
+			if c >= 0 {
+				return false
+			}
+		case "lte":
+			if c > 0 {
+				return false
+			}
+		case "from":
+			if c < 0 || (c == 0 && !includeLower) {
+				return false
+			}
+		case "to":
+			if c > 0 || (c == 0 && !includeUpper) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sortKey is a key of a sort clause.
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// Sorter orders documents according to the sort clause of a search.
+type Sorter struct {
+	keys []sortKey
+}
+
+// NewSorter returns a Sorter for the sort clause of a search, a list of
+// field names or of objects like {"field": {"order": "desc"}}. Scores are
+// ignored.
+func NewSorter(clause []interface{}) (*Sorter, error) {
+	s := new(Sorter)
+	for _, c := range clause {
+		switch k := c.(type) {
+		case string:
+			s.keys = append(s.keys, sortKey{k, k == "_score"})
+		case object:
+			for field, o := range k {
+				order := fmt.Sprint(o)
+				if p, ok := o.(object); ok {
+					order = fmt.Sprint(p["order"])
+				}
+				s.keys = append(s.keys, sortKey{field, strings.EqualFold(order, "desc")})
+			}
+		default:
+			return nil, fmt.Errorf("invalid sort %v", c)
+		}
+	}
+	return s, nil
+}
+
+// Less reports whether the document with source a sorts before the one with
+// source b. Documents missing a field sort last.
+func (s *Sorter) Less(a, b map[string]interface{}) bool {
+	for _, k := range s.keys {
+		if k.field == "_score" {
+			continue
+		}
+		va, vb := fieldValues(a, k.field), fieldValues(b, k.field)
+		switch {
+		case len(va) == 0 && len(vb) == 0:
+			continue
+		case len(va) == 0:
+			return false
+		case len(vb) == 0:
+			return true
+		}
+		c := compare(va[0], vb[0])
+		if c == 0 {
+			continue
+		}
+		return (c < 0) != k.desc
+	}
+	return false
+}
+
+// TermsParams returns the field and the size of a terms facet or
+// aggregation.
+func TermsParams(raw json.RawMessage) (string, int, error) {
+	var facet struct {
+		Terms *struct {
+			Field string `json:"field"`
+			Size  *int   `json:"size"`
+		} `json:"terms"`
+	}
+	if err := json.Unmarshal(raw, &facet); err != nil {
+		return "", 0, err
+	}
+	if facet.Terms == nil {
+		return "", 0, errors.New("only terms facets and aggregations are supported")
+	}
+	size := 10
+	if facet.Terms.Size != nil {
+		size = *facet.Terms.Size
+	}
+	return facet.Terms.Field, size, nil
+}
+
+// Term is a value of a field with its number of documents.
+type Term struct {
+	Value interface{}
+	Count int
+}
+
+// TermCounts returns the size most frequent values of field in sources, the
+// number of documents with other values and the total number of values.
+func TermCounts(sources []map[string]interface{}, field string, size int) ([]Term, int, int) {
+	counts := make(map[string]*Term)
+	total := 0
+	for _, src := range sources {
+		seen := make(map[string]bool)
+		for _, v := range fieldValues(src, field) {
+			k := format(v)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			total++
+			if tc, ok := counts[k]; ok {
+				tc.Count++
+			} else {
+				counts[k] = &Term{v, 1}
+			}
+		}
+	}
+	terms := make([]Term, 0, len(counts))
+	for _, tc := range counts {
+		terms = append(terms, *tc)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Count != terms[j].Count {
+			return terms[i].Count > terms[j].Count
+		}
+		return format(terms[i].Value) < format(terms[j].Value)
+	})
+	other := 0
+	if size > 0 && len(terms) > size {
+		for _, tc := range terms[size:] {
+			other += tc.Count
+		}
+		terms = terms[:size]
+	}
+	return terms, other, total
+}
+
+// point is a geo point.
+type point struct {
+	lat, lon float64
+}
+
+// parsePoint parses a geo point given as {"lat":..,"lon":..}, [lon, lat] or
+// "lat,lon".
+func parsePoint(v interface{}) (point, bool) {
+	switch c := v.(type) {
+	case object:
+		lat, ok1 := c["lat"].(float64)
+		lon, ok2 := c["lon"].(float64)
+		return point{lat, lon}, ok1 && ok2
+	case []interface{}:
+		if len(c) == 2 {
+			lon, ok1 := c[0].(float64)
+			lat, ok2 := c[1].(float64)
+			return point{lat, lon}, ok1 && ok2
+		}
+	case string:
+		parts := strings.Split(c, ",")
+		if len(parts) == 2 {
+			lat, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+			lon, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+			return point{lat, lon}, err1 == nil && err2 == nil
+		}
+	}
+	return point{}, false
+}
+
+// anyPoint reports whether one of the geo points of field in src satisfies
+// f.
+func anyPoint(src object, field string, f func(point) bool) bool {
+	var v interface{} = src
+	for _, name := range strings.Split(field, ".") {
+		o, ok := v.(object)
+		if !ok {
+			return false
+		}
+		v = o[name]
+	}
+	if p, ok := parsePoint(v); ok {
+		return f(p)
+	}
+	list, _ := v.([]interface{})
+	for _, e := range list {
+		if p, ok := parsePoint(e); ok && f(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// distance units, in meters
+var units = []struct {
+	suffix string
+	meters float64
+}{
+	{"nmi", 1852}, {"km", 1000}, {"mi", 1609.344}, {"yd", 0.9144}, {"ft", 0.3048},
+	{"cm", 0.01}, {"mm", 0.001}, {"in", 0.0254}, {"m", 1},
+}
+
+// parseDistance parses a distance like 10km, in meters.
+func parseDistance(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	factor := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, factor = strings.TrimSuffix(s, u.suffix), u.meters
+			break
+		}
+	}
+	d, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid distance %q", s)
+	}
+	return d * factor, nil
+}
+
+// haversine returns the distance between a and b, in meters.
+func haversine(a, b point) float64 {
+	const earthRadius = 6371008.8
+	rad := math.Pi / 180
+	dlat := (b.lat - a.lat) * rad
+	dlon := (b.lon - a.lon) * rad
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(a.lat*rad)*math.Cos(b.lat*rad)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// inPolygon reports whether p is inside polygon, using ray casting.
+func inPolygon(p point, polygon []point) bool {
+	in := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.lat > p.lat) != (b.lat > p.lat) &&
+			p.lon < (b.lon-a.lon)*(p.lat-a.lat)/(b.lat-a.lat)+a.lon {
+			in = !in
+		}
+	}
+	return in
+}
@@ -0,0 +1,155 @@
+package dsl
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+var doc = &Document{Id: "7", Source: map[string]interface{}{
+	"name":     "Blue Watch Store",
+	"rating":   4.5,
+	"tags":     []interface{}{"watches", "repair"},
+	"location": map[string]interface{}{"lat": 43.61, "lon": 3.87},
+	"owner":    map[string]interface{}{"name": "Ann"},
+}}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		query string
+		match bool
+	}{
+		{`{"match_all":{}}`, true},
+		{`{"term":{"name":"watch"}}`, true},
+		{`{"term":{"name":"Blue Watch Store"}}`, true},
+		{`{"term":{"tags":"shoes"}}`, false},
+		{`{"terms":{"tags":["shoes","repair"]}}`, true},
+		{`{"term":{"owner.name":"ann"}}`, true},
+		{`{"ids":{"values":["1","7"]}}`, true},
+		{`{"exists":{"field":"missing"}}`, false},
+		{`{"match":{"name":{"query":"red store","operator":"and"}}}`, false},
+		{`{"match":{"name":"red store"}}`, true},
+		{`{"match_phrase":{"name":"watch store"}}`, true},
+		{`{"match_phrase":{"name":"store watch"}}`, false},
+		{`{"query_string":{"query":"blu* AND \"watch store\""}}`, true},
+		{`{"query_string":{"query":"tags:shoes"}}`, false},
+		{`{"range":{"rating":{"gte":4,"lt":4.5}}}`, false},
+		{`{"range":{"rating":{"from":4,"to":4.5}}}`, true},
+		{`{"bool":{"must":[{"term":{"tags":"watches"}}],"must_not":{"term":{"name":"red"}}}}`, true},
+		{`{"bool":{"must":{"term":{"tags":"watches"}},"should":[{"term":{"name":"red"}}],"minimum_should_match":1}}`, false},
+		{`{"filtered":{"query":{"match_all":{}},"filter":{"term":{"tags":"shoes"}}}}`, false},
+		{`{"geo_distance":{"distance":"10km","location":{"lat":43.6,"lon":3.88}}}`, true},
+		{`{"geo_distance":{"distance":"1m","location":"43.6,3.88"}}`, false},
+		{`{"geo_bounding_box":{"location":{"top_left":{"lat":44,"lon":3},"bottom_right":{"lat":43,"lon":4}}}}`, true},
+		{`{"geo_polygon":{"location":{"points":[{"lat":44,"lon":3},{"lat":44,"lon":4},{"lat":43,"lon":4}]}}}`, true},
+	}
+	for _, test := range tests {
+		var q map[string]interface{}
+		if err := json.Unmarshal([]byte(test.query), &q); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := Match(q, doc); ok != test.match || err != nil {
+			t.Errorf("%s: expected %v, got %v (%v)", test.query, test.match, ok, err)
+		}
+	}
+
+	if _, err := Match(map[string]interface{}{"more_like_this": map[string]interface{}{}}, doc); err == nil {
+		t.Error("unsupported clause accepted")
+	}
+}
+
+func TestSorter(t *testing.T) {
+	s, err := NewSorter([]interface{}{map[string]interface{}{"rating": map[string]interface{}{"order": "desc"}}, "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := []map[string]interface{}{
+		{"name": "b", "rating": 1.0},
+		{"name": "a", "rating": 1.0},
+		{"name": "c"},
+		{"name": "d", "rating": 2.0},
+	}
+	var order []string
+	for len(sources) > 0 {
+		min := 0
+		for i := range sources {
+			if s.Less(sources[i], sources[min]) {
+				min = i
+			}
+		}
+		order = append(order, sources[min]["name"].(string))
+		sources = append(sources[:min], sources[min+1:]...)
+	}
+	if fmt.Sprint(order) != "[d a b c]" {
+		t.Errorf("wrong order %v", order)
+	}
+}
+
+func TestTermCounts(t *testing.T) {
+	field, size, err := TermsParams(json.RawMessage(`{"terms":{"field":"tags","size":2}}`))
+	if err != nil || field != "tags" || size != 2 {
+		t.Fatalf("wrong terms parameters %s %d (%v)", field, size, err)
+	}
+	sources := []map[string]interface{}{
+		{"tags": []interface{}{"a", "b", "a"}},
+		{"tags": "b"},
+		{"tags": []interface{}{"c", "b"}},
+		{},
+	}
+	terms, other, total := TermCounts(sources, field, size)
+	if fmt.Sprint(terms) != "[{b 3} {a 1}]" || other != 1 || total != 5 {
+		t.Errorf("wrong term counts %v, %d other, %d total", terms, other, total)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	src := map[string]interface{}{"a": 1.0, "b": map[string]interface{}{"c": 1.0, "d": 1.0}}
+	merged := Merge(src, map[string]interface{}{"b": map[string]interface{}{"d": 2.0}, "e": 3.0})
+	if fmt.Sprint(merged) != "map[a:1 b:map[c:1 d:2] e:3]" || len(src) != 2 {
+		t.Errorf("wrong merge %v of %v", merged, src)
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	tests := []struct {
+		script string
+		should string
+	}{
+		{"ctx._source.n += params.n", `{"n":3,"name":"a","tags":["x"]}`},
+		{"ctx._source.n -= 1; ctx._source.name += 'b'", `{"n":0,"name":"ab","tags":["x"]}`},
+		{"ctx._source.tags.add(params.tag)", `{"n":1,"name":"a","tags":["x","y"]}`},
+		{"ctx._source.tags += tag", `{"n":1,"name":"a","tags":["x","y"]}`},
+		{"ctx._source.remove('tags'); ctx._source.owner.name = \"ann\"", `{"n":1,"name":"a","owner":{"name":"ann"}}`},
+		{"ctx._source.name = null; ctx._source.ok = true", `{"n":1,"name":null,"ok":true,"tags":["x"]}`},
+		{"ctx._source.n = ctx._source.name", `{"n":"a","name":"a","tags":["x"]}`},
+		{"ctx._source.name += params.missing", ""},
+		{"ctx._source.name -= 1", ""},
+		{"ctx.op = 'delete'", ""},
+	}
+	params := map[string]interface{}{"n": 2, "tag": "y"}
+	for _, test := range tests {
+		src := map[string]interface{}{"n": 1.0, "name": "a", "tags": []interface{}{"x"}}
+		err := RunScript(test.script, params, src)
+		if test.should == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.script)
+			}
+			continue
+		}
+		if b, _ := json.Marshal(src); string(b) != test.should || err != nil {
+			t.Errorf("%s: expected %s, got %s (%v)", test.script, test.should, b, err)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := object{"a": 1.0, "b": "x", "c": object{"d": 1.0, "e": []interface{}{"y"}}, "f": true, "g": nil}
+	new := object{"a": 1.0, "b": "z", "c": object{"d": 1.0}, "h": 2.0}
+	diff := Diff(old, new)
+	if b, _ := json.Marshal(diff); string(b) != `{"b":"z","c":{"e":null},"f":null,"h":2}` {
+		t.Errorf("bad diff %s", b)
+	}
+	if b, _ := json.Marshal(Merge(old, diff)); string(b) != `{"a":1,"b":"z","c":{"d":1,"e":null},"f":null,"g":null,"h":2}` {
+		t.Errorf("bad merged diff %s", b)
+	}
+}
@@ -0,0 +1,177 @@
+package dsl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RunScript runs the update script source on the document source src. It
+// evaluates a small subset of the painless and groovy languages, enough for
+// the usual update scripts: statements separated by semicolons which assign,
+// increment or decrement a field, append to an array field or remove a field:
+//
+//	ctx._source.count += params.inc; ctx._source.tags.add(params.tag)
+//	ctx._source.name = 'new name'; ctx._source.remove('obsolete')
+//
+// Values are numbers, quoted strings, true, false, null, params.name or
+// ctx._source.field. Parameters can also be referred to without the params
+// prefix, like in ES 1.x.
+func RunScript(source string, params, src map[string]interface{}) error {
+	for _, stmt := range strings.Split(source, ";") {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" {
+			continue
+		}
+		if err := runStatement(stmt, params, src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const sourcePrefix = "ctx._source."
+
+// runStatement runs a single statement of a script.
+func runStatement(stmt string, params, src map[string]interface{}) error {
+	if !strings.HasPrefix(stmt, sourcePrefix) {
+		return fmt.Errorf("unsupported script statement %q", stmt)
+	}
+	// method calls
+	if strings.HasSuffix(stmt, ")") {
+		open := strings.Index(stmt, "(")
+		if open < 0 {
+			return fmt.Errorf("unsupported script statement %q", stmt)
+		}
+		target, arg := stmt[len(sourcePrefix):open], stmt[open+1:len(stmt)-1]
+		v, err := eval(arg, params, src)
+		if err != nil {
+			return err
+		}
+		dot := strings.LastIndex(target, ".")
+		switch {
+		case target == "remove":
+			delete(src, fmt.Sprint(v))
+			return nil
+		case dot > 0 && target[dot+1:] == "add":
+			field := target[:dot]
+			list, _ := lookup(src, field).([]interface{})
+			return assign(src, field, append(list, v))
+		}
+		return fmt.Errorf("unsupported script method in %q", stmt)
+	}
+
+	i := strings.Index(stmt, "=")
+	if i < 0 {
+		return fmt.Errorf("unsupported script statement %q", stmt)
+	}
+	field, op := stmt[len(sourcePrefix):i], "="
+	if strings.HasSuffix(field, "+") || strings.HasSuffix(field, "-") {
+		field, op = field[:len(field)-1], field[len(field)-1:]+"="
+	}
+	field = strings.TrimSpace(field)
+	v, err := eval(stmt[i+1:], params, src)
+	if err != nil {
+		return err
+	}
+	if op == "=" {
+		return assign(src, field, v)
+	}
+	switch c := lookup(src, field).(type) {
+	case []interface{}:
+		if op == "+=" {
+			return assign(src, field, append(c, v))
+		}
+	case string:
+		if op == "+=" {
+			return assign(src, field, c+format(v))
+		}
+	case float64, nil:
+		a, _ := c.(float64)
+		b, ok := v.(float64)
+		if !ok {
+			return fmt.Errorf("%s %s: %v is not a number", field, op, v)
+		}
+		if op == "-=" {
+			b = -b
+		}
+		return assign(src, field, a+b)
+	}
+	return fmt.Errorf("unsupported %s on field %s", op, field)
+}
+
+// eval returns the value of the expression expr.
+func eval(expr string, params, src map[string]interface{}) (interface{}, error) {
+	expr = strings.TrimSpace(expr)
+	switch {
+	case expr == "true", expr == "false":
+		return expr == "true", nil
+	case expr == "null":
+		return nil, nil
+	case len(expr) >= 2 && (expr[0] == '\'' || expr[0] == '"') && expr[len(expr)-1] == expr[0]:
+		return expr[1 : len(expr)-1], nil
+	case strings.HasPrefix(expr, "params."):
+		name := expr[len("params."):]
+		v, ok := params[name]
+		if !ok {
+			return nil, fmt.Errorf("missing script parameter %s", name)
+		}
+		return normalizeNumber(v), nil
+	case strings.HasPrefix(expr, sourcePrefix):
+		return lookup(src, expr[len(sourcePrefix):]), nil
+	}
+	if f, err := strconv.ParseFloat(expr, 64); err == nil {
+		return f, nil
+	}
+	if v, ok := params[expr]; ok {
+		return normalizeNumber(v), nil
+	}
+	return nil, fmt.Errorf("unsupported script expression %q", expr)
+}
+
+// normalizeNumber converts the numbers of parameters to float64, like the
+// numbers of decoded JSON documents.
+func normalizeNumber(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	}
+	return v
+}
+
+// lookup returns the value of the dotted field path in src, nil if missing.
+func lookup(src map[string]interface{}, path string) interface{} {
+	var v interface{} = src
+	for _, part := range strings.Split(path, ".") {
+		o, ok := v.(object)
+		if !ok {
+			return nil
+		}
+		v = o[part]
+	}
+	return v
+}
+
+// assign sets the dotted field path of src to v, creating the intermediate
+// objects.
+func assign(src map[string]interface{}, path string, v interface{}) error {
+	parts := strings.Split(path, ".")
+	o := src
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := o[part].(object)
+		if !ok {
+			if o[part] != nil {
+				return fmt.Errorf("%s is not an object", part)
+			}
+			next = make(object)
+			o[part] = next
+		}
+		o = next
+	}
+	o[parts[len(parts)-1]] = v
+	return nil
+}
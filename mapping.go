@@ -1,7 +1,9 @@
 package goose
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"strings"
 )
@@ -14,6 +16,11 @@ const (
 	TYPE_GEOPOINT = MappingType("geo_point")
 	TYPE_STRING   = MappingType("string")
 
+	// Full-text and exact-value strings. They are mapped to analyzed and
+	// not analyzed string fields before ES 5.x.
+	TYPE_TEXT    = MappingType("text")
+	TYPE_KEYWORD = MappingType("keyword")
+
 	TYPE_BYTE    = MappingType("byte")
 	TYPE_SHORT   = MappingType("short")
 	TYPE_INTEGER = MappingType("integer")
@@ -64,6 +71,30 @@ func (mb *MappingBuilder) ToJSON() (string, error) {
 	return string(b), err
 }
 
+// ToJSONDialect is like ToJSON but returns a mapping suitable for the ES
+// versions of dialect d: string fields are text fields in ES 5.x and later,
+// text and keyword fields are analyzed and not analyzed string fields before.
+func (mb *MappingBuilder) ToJSONDialect(d Dialect) (string, error) {
+	properties := make(map[string]M, len(mb.Properties))
+	for name, p := range mb.Properties {
+		c := make(M, len(p))
+		for k, v := range p {
+			c[k] = v
+		}
+		switch t := p["type"]; {
+		case d >= DIALECT_5X && t == TYPE_STRING:
+			c["type"] = TYPE_TEXT
+		case d < DIALECT_5X && t == TYPE_TEXT:
+			c["type"] = TYPE_STRING
+		case d < DIALECT_5X && t == TYPE_KEYWORD:
+			c["type"] = TYPE_STRING
+			c["index"] = "not_analyzed"
+		}
+		properties[name] = c
+	}
+	return (&MappingBuilder{Properties: properties}).ToJSON()
+}
+
 // sets a mapping for the object
 // Caller is responsible for closing and opening index if necessary
 // For example, the following snippet
@@ -78,58 +109,112 @@ func (mb *MappingBuilder) ToJSON() (string, error) {
 //		}
 //  }
 func (se *ElasticSearch) SetMapping(object ElasticObject, m *MappingBuilder) error {
-	mapping, err := m.ToJSON()
+	return se.SetMappingContext(context.Background(), object, m)
+}
+
+// SetMappingContext is like SetMapping but uses ctx to bound the request.
+func (se *ElasticSearch) SetMappingContext(ctx context.Context, object ElasticObject, m *MappingBuilder) error {
+	d, err := se.dialect(ctx)
+	if err != nil {
+		return err
+	}
+	mapping, err := m.ToJSONDialect(d)
 	if err != nil {
 		return err
 	}
 
-	return se.SetMappingRawJSON(object, mapping)
+	return se.SetMappingRawJSONContext(ctx, object, mapping)
 }
 
 // sets a mapping for the object.
 // Caller is responsible for closing and opening index if necessary
 func (se *ElasticSearch) SetMappingRawJSON(object ElasticObject, mapping string) error {
-	path, err := buildPath(object)
+	return se.SetMappingRawJSONContext(context.Background(), object, mapping)
+}
+
+// SetMappingRawJSONContext is like SetMappingRawJSON but uses ctx to bound
+// the request.
+func (se *ElasticSearch) SetMappingRawJSONContext(ctx context.Context, object ElasticObject, mapping string) error {
+	path, err := se.mappingPath(ctx, object)
 	if err != nil {
 		return err
 	}
 
 	body := strings.NewReader(mapping)
 
-	return se.sendRequest(PUT, se.serverUrl+se.basePath+path+actionMappings, body)
+	return se.sendRequest(ctx, "SetMapping", PUT, path, body)
 }
 
 // gets the current mapping of the object
 // TODO: return a MappingResult
 func (se *ElasticSearch) GetMapping(object ElasticObject) (string, error) {
-	path, err := buildPath(object)
+	return se.GetMappingContext(context.Background(), object)
+}
+
+// GetMappingContext is like GetMapping but uses ctx to bound the request.
+func (se *ElasticSearch) GetMappingContext(ctx context.Context, object ElasticObject) (string, error) {
+	path, err := se.mappingPath(ctx, object)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := se.sendRequestAndGetResponse(GET, se.serverUrl+se.basePath+path+actionMappings, nil)
-	defer resp.Body.Close()
+	resp, err := se.sendRequestAndGetResponse(ctx, "GetMapping", GET, path, nil)
 	if err != nil {
 		return "", err
 	}
+	defer resp.Body.Close()
 	bytes, err := ioutil.ReadAll(resp.Body)
 	return string(bytes), err
 }
 
 // deletes the current mapping of the object along with its data
-// FIXME: won't work with ES 2.x. It is no longer possible to delete the mapping for a type.
+// Fails with ES 2.x and later. It is no longer possible to delete the mapping for a type.
 // See https://www.elastic.co/guide/en/elasticsearch/reference/2.0/indices-delete-mapping.html
 func (se *ElasticSearch) DeleteMappingAndData(object ElasticObject) (string, error) {
+	return se.DeleteMappingAndDataContext(context.Background(), object)
+}
+
+// DeleteMappingAndDataContext is like DeleteMappingAndData but uses ctx to
+// bound the request.
+func (se *ElasticSearch) DeleteMappingAndDataContext(ctx context.Context, object ElasticObject) (string, error) {
+	d, err := se.dialect(ctx)
+	if err != nil {
+		return "", err
+	}
+	if d > DIALECT_1X {
+		return "", errors.New("deleting a mapping is not supported since ES 2.x")
+	}
 	path, err := buildPath(object)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := se.sendRequestAndGetResponse(DELETE, se.serverUrl+se.basePath+path+actionMapping, nil)
-	defer resp.Body.Close()
+	resp, err := se.sendRequestAndGetResponse(ctx, "DeleteMappingAndData", DELETE, se.basePath+path+actionMapping, nil)
 	if err != nil {
 		return "", err
 	}
+	defer resp.Body.Close()
 	bytes, err := ioutil.ReadAll(resp.Body)
 	return string(bytes), err
 }
+
+// returns the path of the mapping of the object's type. ES 7.x requires
+// include_type_name to accept typed mappings.
+func (se *ElasticSearch) mappingPath(ctx context.Context, object ElasticObject) (string, error) {
+	v, err := se.VersionContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	d := v.Dialect()
+	path, err := se.typePath(d, object)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case d == DIALECT_1X:
+		return path + actionMappings, nil
+	case v.Major == 7:
+		return path + actionMapping + "?include_type_name=true", nil
+	}
+	return path + actionMapping, nil
+}
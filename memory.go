@@ -0,0 +1,610 @@
+package goose
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/gotsunami/goose/internal/dsl"
+)
+
+// MemoryEngine is a SearchEngine keeping the objects in memory, for small
+// deployments and tests which do not need an ES cluster.
+//
+// Objects are stored as JSON documents by type and key. Queries of a
+// QueryBuilder, or raw JSON queries, are evaluated on these documents: term,
+// terms, match, query_string (terms, phrases, wildcards and field prefixes),
+// range, exists, ids, bool and geo distance, bounding box or polygon clauses
+// are supported, along with sorting and terms facets. Text is analyzed like
+// the standard analyzer does: values are split in lowercase words, but terms
+// facets count whole values like on not analyzed fields. Scores are not
+// computed, hits are sorted by insertion order unless sorted otherwise.
+//
+// Writes of Versioned objects are conditioned on their version like with ES
+// 6.7 and later, the primary term being always 1.
+//
+// A MemoryEngine is safe for concurrent use.
+type MemoryEngine struct {
+	// ModifyAttempts is the maximum number of read-modify-write cycles made
+	// by Modify when the element is changed concurrently, 5 if 0.
+	ModifyAttempts int
+
+	mu    sync.RWMutex
+	types map[string]map[string]*memoryDoc // documents by type path and key
+	seq   int64
+	seqNo int64 // sequence number of the next write
+}
+
+// memoryDoc is a document stored by a MemoryEngine.
+type memoryDoc struct {
+	source  json.RawMessage
+	seq     int64 // insertion order
+	version int64
+	seqNo   int64
+}
+
+// docVersion returns the version of the document d.
+func (d *memoryDoc) docVersion() DocVersion {
+	return DocVersion{Version: d.version, SeqNo: d.seqNo, PrimaryTerm: 1}
+}
+
+// checkVersion returns a version conflict error if object is Versioned and
+// does not match the document d, nil if the document does not exist. The
+// caller must hold the lock.
+func checkVersion(path string, object ElasticObject, d *memoryDoc) error {
+	vo, ok := object.(Versioned)
+	if !ok {
+		return nil
+	}
+	dv := vo.DocVersion()
+	var reason string
+	switch {
+	case dv.PrimaryTerm == 0 && dv.Version == 0:
+		return nil
+	case d == nil:
+		reason = "document does not exist"
+	case dv.PrimaryTerm > 0 && (dv.SeqNo != d.seqNo || dv.PrimaryTerm != 1):
+		reason = fmt.Sprintf("required seqNo [%d], primary term [%d]. current document has seqNo [%d] and primary term [1]",
+			dv.SeqNo, dv.PrimaryTerm, d.seqNo)
+	case dv.PrimaryTerm == 0 && dv.Version != d.version:
+		reason = fmt.Sprintf("current version [%d] is different than the one provided [%d]", d.version, dv.Version)
+	default:
+		return nil
+	}
+	return &Error{
+		Status: 409,
+		Type:   "version_conflict_engine_exception",
+		Reason: fmt.Sprintf("[%s][%s]: version conflict, %s", path[:len(path)-1], object.Key(), reason),
+	}
+}
+
+// setVersion sets the version of object to the one of the document d if
+// object is Versioned.
+func setVersion(object ElasticObject, d *memoryDoc) {
+	if vo, ok := object.(Versioned); ok {
+		vo.SetDocVersion(d.docVersion())
+	}
+}
+
+// NewMemoryEngine returns an empty MemoryEngine.
+func NewMemoryEngine() *MemoryEngine {
+	return &MemoryEngine{types: make(map[string]map[string]*memoryDoc)}
+}
+
+// documentMissing returns the error replied by ES for a missing document.
+func documentMissing(path, key string) error {
+	return &Error{
+		Status: 404,
+		Type:   "document_missing_exception",
+		Reason: fmt.Sprintf("[%s][%s]: document missing", path[:len(path)-1], key),
+	}
+}
+
+// adds an element to the engine, replacing any element with the same key.
+func (me *MemoryEngine) Insert(object ElasticObject) error {
+	return me.InsertContext(context.Background(), object)
+}
+
+// InsertContext is like Insert but returns ctx.Err() if ctx is done.
+func (me *MemoryEngine) InsertContext(ctx context.Context, object ElasticObject) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := buildPath(object)
+	if err != nil {
+		return err
+	}
+	jsondata, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if err = checkVersion(path, object, me.types[path][object.Key()]); err != nil {
+		return err
+	}
+	setVersion(object, me.put(path, object.Key(), jsondata))
+	return nil
+}
+
+// put stores the document source at key in the type path and returns it.
+// The caller must hold the lock.
+func (me *MemoryEngine) put(path, key string, source []byte) *memoryDoc {
+	docs := me.types[path]
+	if docs == nil {
+		docs = make(map[string]*memoryDoc)
+		me.types[path] = docs
+	}
+	d, ok := docs[key]
+	if !ok {
+		me.seq++
+		d = &memoryDoc{seq: me.seq}
+		docs[key] = d
+	}
+	d.source = source
+	d.version++
+	d.seqNo = me.seqNo
+	me.seqNo++
+	return d
+}
+
+// Create adds an element to the engine unless an element with the same key
+// exists, in which case it fails with a conflict error.
+func (me *MemoryEngine) Create(object ElasticObject) error {
+	return me.CreateContext(context.Background(), object)
+}
+
+// CreateContext is like Create but returns ctx.Err() if ctx is done.
+func (me *MemoryEngine) CreateContext(ctx context.Context, object ElasticObject) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := buildPath(object)
+	if err != nil {
+		return err
+	}
+	jsondata, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if d, ok := me.types[path][object.Key()]; ok {
+		return &Error{
+			Status: 409,
+			Type:   "version_conflict_engine_exception",
+			Reason: fmt.Sprintf("[%s][%s]: version conflict, document already exists (current version [%d])",
+				path[:len(path)-1], object.Key(), d.version),
+		}
+	}
+	setVersion(object, me.put(path, object.Key(), jsondata))
+	return nil
+}
+
+// InsertWithGeneratedId adds an element to the engine under a random id,
+// which is returned. object.Key() is not used.
+func (me *MemoryEngine) InsertWithGeneratedId(object ElasticObject) (string, error) {
+	return me.InsertWithGeneratedIdContext(context.Background(), object)
+}
+
+// InsertWithGeneratedIdContext is like InsertWithGeneratedId but returns
+// ctx.Err() if ctx is done.
+func (me *MemoryEngine) InsertWithGeneratedIdContext(ctx context.Context, object ElasticObject) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	path, err := buildPath(object)
+	if err != nil {
+		return "", err
+	}
+	jsondata, err := json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+	// 120 random bits, encoded like the ids generated by ES
+	b := make([]byte, 15)
+	if _, err = rand.Read(b); err != nil {
+		return "", err
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	setVersion(object, me.put(path, id, jsondata))
+	return id, nil
+}
+
+// BulkInsert adds several objects at once.
+func (me *MemoryEngine) BulkInsert(objects []ElasticObject) error {
+	return me.BulkInsertContext(context.Background(), objects)
+}
+
+// BulkInsertContext is like BulkInsert but returns ctx.Err() if ctx is done.
+func (me *MemoryEngine) BulkInsertContext(ctx context.Context, objects []ElasticObject) error {
+	if len(objects) == 0 {
+		return errors.New("no object to bulk insert")
+	}
+	for _, object := range objects {
+		if err := me.InsertContext(ctx, object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updates an element, merging its non-empty JSON fields into the stored
+// one like the ES _update API does.
+func (me *MemoryEngine) Update(object ElasticObject) error {
+	return me.UpdateContext(context.Background(), object)
+}
+
+// UpdateContext is like Update but returns ctx.Err() if ctx is done.
+func (me *MemoryEngine) UpdateContext(ctx context.Context, object ElasticObject) error {
+	return me.UpdateWithContext(ctx, object, nil)
+}
+
+// updates an element as defined by ub, like the ES _update API does: the
+// element or the partial document of ub is merged into the stored one, or
+// the script of ub is run on it. The scripts supported are the simple
+// assignments, increments and array appends of goosetest. A nil ub merges
+// object.
+func (me *MemoryEngine) UpdateWith(object ElasticObject, ub *UpdateBuilder) error {
+	return me.UpdateWithContext(context.Background(), object, ub)
+}
+
+// UpdateWithContext is like UpdateWith but returns ctx.Err() if ctx is done.
+func (me *MemoryEngine) UpdateWithContext(ctx context.Context, object ElasticObject, ub *UpdateBuilder) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ub == nil {
+		ub = NewUpdateBuilder()
+	}
+	if err := ub.checkVersioned(object); err != nil {
+		return err
+	}
+	path, err := buildPath(object)
+	if err != nil {
+		return err
+	}
+	doc, err := ub.partialDoc(object)
+	if err != nil {
+		return err
+	}
+	var params map[string]interface{}
+	if ub.script != nil {
+		if err = roundTrip(ub.script.Params, &params); err != nil {
+			return err
+		}
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	d, ok := me.types[path][object.Key()]
+	if !ok && ub.upsert == nil && (!ub.docAsUpsert || ub.script != nil) {
+		return documentMissing(path, object.Key())
+	}
+	if err = checkVersion(path, object, d); err != nil {
+		return err
+	}
+	var jsondata []byte
+	switch {
+	case !ok && ub.upsert != nil:
+		jsondata, err = json.Marshal(ub.upsert)
+	case !ok:
+		jsondata, err = json.Marshal(doc)
+	case ub.script != nil:
+		var src map[string]interface{}
+		if err = json.Unmarshal(d.source, &src); err != nil {
+			return err
+		}
+		if err = dsl.RunScript(ub.script.Source, params, src); err != nil {
+			return &Error{Status: 400, Type: "illegal_argument_exception", Reason: "failed to execute script: " + err.Error()}
+		}
+		jsondata, err = json.Marshal(src)
+	default:
+		var src map[string]interface{}
+		if err = json.Unmarshal(d.source, &src); err != nil {
+			return err
+		}
+		jsondata, err = json.Marshal(dsl.Merge(src, doc))
+	}
+	if err != nil {
+		return err
+	}
+	setVersion(object, me.put(path, object.Key(), jsondata))
+	if ub.returnSource {
+		return json.Unmarshal(jsondata, object)
+	}
+	return nil
+}
+
+// gets an element by key. Returns false and a not found error if there is no
+// such element.
+func (me *MemoryEngine) Get(object ElasticObject) (bool, error) {
+	return me.GetContext(context.Background(), object)
+}
+
+// GetContext is like Get but returns ctx.Err() if ctx is done.
+func (me *MemoryEngine) GetContext(ctx context.Context, object ElasticObject) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	path, err := buildPath(object)
+	if err != nil {
+		return false, err
+	}
+	me.mu.RLock()
+	d, ok := me.types[path][object.Key()]
+	var source json.RawMessage
+	if ok {
+		source = d.source
+		setVersion(object, d)
+	}
+	me.mu.RUnlock()
+	if !ok {
+		return false, documentMissing(path, object.Key())
+	}
+	return true, json.Unmarshal(source, object)
+}
+
+// deletes an element
+func (me *MemoryEngine) Delete(object ElasticObject) error {
+	return me.DeleteContext(context.Background(), object)
+}
+
+// DeleteContext is like Delete but returns ctx.Err() if ctx is done.
+func (me *MemoryEngine) DeleteContext(ctx context.Context, object ElasticObject) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := buildPath(object)
+	if err != nil {
+		return err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	d, ok := me.types[path][object.Key()]
+	if err = checkVersion(path, object, d); err != nil {
+		return err
+	}
+	if !ok {
+		return documentMissing(path, object.Key())
+	}
+	delete(me.types[path], object.Key())
+	d.version++
+	d.seqNo = me.seqNo
+	me.seqNo++
+	setVersion(object, d)
+	return nil
+}
+
+// deletes the elements of the type of object matching the query q
+func (me *MemoryEngine) DeleteByQuery(object ElasticObject, q *QueryBuilder) (*DeletedIndex, error) {
+	return me.DeleteByQueryContext(context.Background(), object, q)
+}
+
+// DeleteByQueryContext is like DeleteByQuery but returns ctx.Err() if ctx is
+// done.
+func (me *MemoryEngine) DeleteByQueryContext(ctx context.Context, object ElasticObject, q *QueryBuilder) (*DeletedIndex, error) {
+	if q == nil {
+		return nil, errors.New("Query is not valid")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	req, err := parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	path, err := buildPath(object)
+	if err != nil {
+		return nil, err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	hits, err := me.match(path, req.Query)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hits {
+		delete(me.types[path], h.Id)
+	}
+	index := &DeletedIndex{Deleted: len(hits)}
+	index.Shards.Total, index.Shards.Successful = 1, 1
+	return index, nil
+}
+
+// Refresh does nothing: the writes of a MemoryEngine are visible at once.
+func (me *MemoryEngine) Refresh() error {
+	return nil
+}
+
+// RefreshContext is like Refresh but returns ctx.Err() if ctx is done.
+func (me *MemoryEngine) RefreshContext(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Count returns the number of elements of the type of object.
+func (me *MemoryEngine) Count(object ElasticObject) (int, error) {
+	return me.CountContext(context.Background(), object)
+}
+
+// CountContext is like Count but returns ctx.Err() if ctx is done.
+func (me *MemoryEngine) CountContext(ctx context.Context, object ElasticObject) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	path, err := buildPath(object)
+	if err != nil {
+		return 0, err
+	}
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return len(me.types[path]), nil
+}
+
+// Performs a search
+func (me *MemoryEngine) Search(object ElasticObject, qb *QueryBuilder) (*ResultSet, error) {
+	return me.SearchContext(context.Background(), object, qb)
+}
+
+// SearchContext is like Search but returns ctx.Err() if ctx is done.
+func (me *MemoryEngine) SearchContext(ctx context.Context, object ElasticObject, qb *QueryBuilder) (*ResultSet, error) {
+	return me.search(ctx, object, qb, false)
+}
+
+// Performs a search count: only the total number of hits and the facets are
+// returned.
+func (me *MemoryEngine) SearchCount(object ElasticObject, qb *QueryBuilder) (*ResultSet, error) {
+	return me.SearchCountContext(context.Background(), object, qb)
+}
+
+// SearchCountContext is like SearchCount but returns ctx.Err() if ctx is
+// done.
+func (me *MemoryEngine) SearchCountContext(ctx context.Context, object ElasticObject, qb *QueryBuilder) (*ResultSet, error) {
+	return me.search(ctx, object, qb, true)
+}
+
+// performs a search with a JSON query in the ES query DSL.
+func (me *MemoryEngine) SearchRawJSON(object ElasticObject, jsondata string) (*ResultSet, error) {
+	return me.SearchRawJSONContext(context.Background(), object, jsondata)
+}
+
+// SearchRawJSONContext is like SearchRawJSON but returns ctx.Err() if ctx is
+// done.
+func (me *MemoryEngine) SearchRawJSONContext(ctx context.Context, object ElasticObject, jsondata string) (*ResultSet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	req, err := dsl.ParseRequest([]byte(jsondata))
+	if err != nil {
+		return nil, err
+	}
+	return me.searchRequest(object, req)
+}
+
+func (me *MemoryEngine) search(ctx context.Context, object ElasticObject, qb *QueryBuilder, count bool) (*ResultSet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	req := new(dsl.Request)
+	if qb != nil {
+		var err error
+		if req, err = parseQuery(qb); err != nil {
+			return nil, err
+		}
+	}
+	if count {
+		size := 0
+		req.Size = &size
+	}
+	return me.searchRequest(object, req)
+}
+
+// parseQuery converts the query of qb to a search request.
+func parseQuery(qb *QueryBuilder) (*dsl.Request, error) {
+	jsondata, err := qb.ToJSONDialect(DIALECT_5X)
+	if err != nil {
+		return nil, err
+	}
+	return dsl.ParseRequest([]byte(jsondata))
+}
+
+// searchRequest executes a search request on the elements of the type of
+// object.
+func (me *MemoryEngine) searchRequest(object ElasticObject, req *dsl.Request) (*ResultSet, error) {
+	path, err := buildPath(object)
+	if err != nil {
+		return nil, err
+	}
+	sorter, err := dsl.NewSorter(req.Sort)
+	if err != nil {
+		return nil, err
+	}
+	me.mu.RLock()
+	hits, err := me.match(path, req.Query)
+	me.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return sorter.Less(hits[i].Source, hits[j].Source) })
+
+	rset := new(ResultSet)
+	rset.Hits.Total = len(hits)
+	from, size := 0, 10 // ES defaults
+	if req.From != nil {
+		from = *req.From
+	}
+	if req.Size != nil {
+		size = *req.Size
+	}
+	v := reflect.Indirect(reflect.ValueOf(object)).Type()
+	for i := from; i < len(hits) && i < from+size; i++ {
+		no := reflect.New(v).Interface()
+		if err = json.Unmarshal(hits[i].source, no); err != nil {
+			return rset, err
+		}
+		rset.Hits.Data = append(rset.Hits.Data, ResultHit{Id: hits[i].Id, Src: hits[i].Source, Object: no})
+	}
+
+	facets := req.Facets
+	if len(facets) == 0 {
+		facets = req.Aggs
+	}
+	sources := make([]map[string]interface{}, len(hits))
+	for i, h := range hits {
+		sources[i] = h.Source
+	}
+	for name, raw := range facets {
+		field, fsize, err := dsl.TermsParams(raw)
+		if err != nil {
+			return nil, err
+		}
+		if rset.Facets == nil {
+			rset.Facets = make(map[string]ResultFacet, len(facets))
+		}
+		terms, _, total := dsl.TermCounts(sources, field, fsize)
+		facet := ResultFacet{Total: total, Terms: make([]M, len(terms))}
+		for i, t := range terms {
+			facet.Terms[i] = M{"term": t.Value, "count": t.Count}
+		}
+		rset.Facets[name] = facet
+	}
+	return rset, nil
+}
+
+// memoryHit is a document matched by a query.
+type memoryHit struct {
+	dsl.Document
+	source json.RawMessage
+	seq    int64
+}
+
+// match returns the documents of the type path matching query, in insertion
+// order. The caller must hold the lock.
+func (me *MemoryEngine) match(path string, query map[string]interface{}) ([]*memoryHit, error) {
+	var hits []*memoryHit
+	for key, d := range me.types[path] {
+		h := &memoryHit{Document: dsl.Document{Id: key}, source: d.source, seq: d.seq}
+		if err := json.Unmarshal(d.source, &h.Source); err != nil {
+			return nil, err
+		}
+		if query != nil {
+			ok, err := dsl.Match(query, &h.Document)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		hits = append(hits, h)
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].seq < hits[j].seq })
+	return hits, nil
+}
@@ -0,0 +1,145 @@
+package goose
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+var memoryObjects = []*DummyObject{
+	{1, "Blue watch store", 4.5, Location{43.61, 3.87}},  // Montpellier
+	{2, "Red shoes", 3.2, Location{43.30, 5.37}},         // Marseille
+	{3, "Watch repair shop", 4.9, Location{48.85, 2.35}}, // Paris
+}
+
+func newMemoryEngine(t *testing.T) *MemoryEngine {
+	me := NewMemoryEngine()
+	objects := make([]ElasticObject, len(memoryObjects))
+	for i, o := range memoryObjects {
+		objects[i] = o
+	}
+	if err := me.BulkInsert(objects); err != nil {
+		t.Fatal("Cannot bulk insert:", err)
+	}
+	return me
+}
+
+func TestMemoryEngineCrud(t *testing.T) {
+	me := newMemoryEngine(t)
+	if n, err := me.Count(&DummyObject{}); n != 3 || err != nil {
+		t.Errorf("wrong count %d (%v)", n, err)
+	}
+
+	got := &DummyObject{Id: 2}
+	if found, err := me.Get(got); !found || err != nil || *got != *memoryObjects[1] {
+		t.Errorf("wrong object %+v (%v)", got, err)
+	}
+	if found, err := me.Get(&DummyObject{Id: 42}); found || !IsNotFound(err) {
+		t.Error("expected a not found error, got", err)
+	}
+
+	// zero values are not omitted, so the whole object is merged
+	if err := me.Update(&DummyObject{Id: 2, Description: "Green shoes"}); err != nil {
+		t.Error("Cannot update:", err)
+	}
+	me.Get(got)
+	if got.Description != "Green shoes" || got.Len != 0 {
+		t.Errorf("wrong updated object %+v", got)
+	}
+	if err := me.Update(&DummyObject{Id: 42}); !IsNotFound(err) {
+		t.Error("expected a document missing error, got", err)
+	}
+
+	if err := me.Delete(got); err != nil {
+		t.Error("Cannot delete:", err)
+	}
+	if err := me.Delete(got); !IsNotFound(err) {
+		t.Error("expected a not found error, got", err)
+	}
+	if n, _ := me.Count(&DummyObject{}); n != 2 {
+		t.Errorf("expected 2 objects left, got %d", n)
+	}
+
+	if err := me.Insert(&anotherObject{}); err != nil {
+		t.Error("Cannot insert:", err)
+	}
+	if n, _ := me.Count(&anotherObject{}); n != 1 {
+		t.Errorf("objects of different types mixed: %d objects", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := me.InsertContext(ctx, memoryObjects[1]); err != context.Canceled {
+		t.Error("expected a canceled error, got", err)
+	}
+}
+
+type anotherObject struct{}
+
+func (a *anotherObject) Key() string {
+	return "1"
+}
+
+func TestMemoryEngineSearch(t *testing.T) {
+	var engine SearchEngine = newMemoryEngine(t)
+	montpellier := Location{43.61, 3.88}
+	tests := []struct {
+		name   string
+		qb     *QueryBuilder
+		should []int
+	}{
+		{"all", nil, []int{1, 2, 3}},
+		{"term", NewQueryBuilder().SetTerm("description", "watch"), []int{1, 3}},
+		{"query string", NewQueryBuilder().AddQueryString("description", "sho*"), []int{2, 3}},
+		{"fuzzy", NewQueryBuilder().AddFuzzySearch("description", "red shoes"), []int{2}},
+		{"range", NewQueryBuilder().AddFloatRange("len", 4, 4.8), []int{1}},
+		{"greater than", NewQueryBuilder().AddGreaterThanRange("len", 4), []int{1, 3}},
+		{"int range", NewQueryBuilder().AddRange("id", 2, 3), []int{2, 3}},
+		{"distance", NewQueryBuilder().AddGeoDistance("hq", montpellier, 200, KM), []int{1, 2}},
+		{"bounding box", NewQueryBuilder().AddGeoBoundingBox("hq", Location{50, 0}, Location{43.5, 4}), []int{1, 3}},
+		{"sort", NewQueryBuilder().AddSort("len", ORDER_DESC, MODE_DEF), []int{3, 1, 2}},
+		{"combined", NewQueryBuilder().SetTerm("description", "watch").AddSort("id", ORDER_DESC, MODE_DEF), []int{3, 1}},
+	}
+	for _, test := range tests {
+		rset, err := engine.Search(&DummyObject{}, test.qb)
+		if err != nil {
+			t.Errorf("%s search fails: %v", test.name, err)
+			continue
+		}
+		var ids []int
+		for _, hit := range rset.Hits.Data {
+			ids = append(ids, hit.Object.(*DummyObject).Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.should) || rset.Hits.Total != len(test.should) {
+			t.Errorf("wrong %s results. Expected %v, got %v (total %d)", test.name, test.should, ids, rset.Hits.Total)
+		}
+	}
+
+	engine.Insert(&DummyObject{4, "Watch outlet", 4.5, Location{45.76, 4.83}})
+	qb := NewQueryBuilder().SetTermFacet("lengths", "len", 1, nil)
+	rset, err := engine.SearchCount(&DummyObject{}, qb)
+	if err != nil {
+		t.Fatal("facet search fails:", err)
+	}
+	facet := rset.Facets["lengths"]
+	if len(rset.Hits.Data) != 0 || rset.Hits.Total != 4 || facet.Total != 4 || len(facet.Terms) != 1 ||
+		facet.Terms[0]["term"] != 4.5 || facet.Terms[0]["count"] != 2 {
+		t.Errorf("wrong facet results %+v", rset)
+	}
+
+	rset, err = engine.SearchRawJSON(&DummyObject{}, `{"query":{"ids":{"values":["2"]}},"size":1}`)
+	if err != nil || rset.Hits.Total != 1 || rset.Hits.Data[0].Id != "2" || rset.Hits.Data[0].Src["len"] != 3.2 {
+		t.Errorf("wrong raw search results %+v (%v)", rset, err)
+	}
+	if _, err := engine.SearchRawJSON(&DummyObject{}, `{"query":{"fuzzy_like_this":{}}}`); err == nil {
+		t.Error("unsupported query accepted")
+	}
+
+	deleted, err := engine.DeleteByQuery(&DummyObject{}, NewQueryBuilder().SetTerm("description", "watch"))
+	if err != nil || deleted.Deleted != 3 {
+		t.Errorf("wrong deletion %+v (%v)", deleted, err)
+	}
+	if n, _ := engine.Count(&DummyObject{}); n != 1 {
+		t.Errorf("expected 1 object left, got %d", n)
+	}
+}
@@ -0,0 +1,184 @@
+package goose
+
+import (
+	"sync"
+	"time"
+)
+
+// OperationMetrics describes an API call, i.e Insert or Search, once it is
+// over. Retries of its request are part of the same operation.
+type OperationMetrics struct {
+	Operation string        // Name of the API call
+	Index     string        // Index of the instance
+	Status    int           // HTTP status code, 0 if no response was received
+	Latency   time.Duration // Time to get the response headers, retries included
+	BytesOut  int64         // Size of the request body
+	BytesIn   int64         // Size of the response body read by goose
+	Retries   int           // Number of retries of the request
+	Err       error         // Error of the request, if any
+}
+
+// Metrics is notified of every operation made by an instance, once its
+// response body has been consumed.
+type Metrics interface {
+	Observe(*OperationMetrics)
+}
+
+func (se *ElasticSearch) observe(om *OperationMetrics) {
+	if se.metrics != nil {
+		se.metrics.Observe(om)
+	}
+}
+
+// DefaultLatencyBuckets are the upper bounds of the latency histograms of a
+// MemoryMetrics created without buckets.
+var DefaultLatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram counts latencies in buckets. Counts[i] is the number of
+// latencies lower than or equal to Buckets[i] and greater than Buckets[i-1].
+// The last count, Counts[len(Buckets)], is the number of latencies greater
+// than all buckets.
+type Histogram struct {
+	Buckets []time.Duration
+	Counts  []int64
+	Count   int64
+	Sum     time.Duration
+	Max     time.Duration
+}
+
+func newHistogram(buckets []time.Duration) Histogram {
+	return Histogram{Buckets: buckets, Counts: make([]int64, len(buckets)+1)}
+}
+
+func (h *Histogram) add(d time.Duration) {
+	i := 0
+	for i < len(h.Buckets) && d > h.Buckets[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// Mean returns the average latency.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns an upper bound of the q-quantile of the latencies (0 <= q
+// <= 1), i.e Quantile(0.99) is the upper bound of the bucket holding the 99th
+// percentile. Latencies greater than all buckets are bound by Max.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(h.Count))
+	if rank < 1 {
+		rank = 1
+	}
+	var n int64
+	for i, c := range h.Counts {
+		n += c
+		if n >= rank {
+			if i < len(h.Buckets) {
+				return h.Buckets[i]
+			}
+			break
+		}
+	}
+	return h.Max
+}
+
+// OperationStats aggregates the metrics of an operation.
+type OperationStats struct {
+	Count    int64         // Number of calls
+	Errors   int64         // Number of failed calls
+	Retries  int64         // Total number of retries
+	BytesOut int64         // Total size of request bodies
+	BytesIn  int64         // Total size of response bodies
+	Status   map[int]int64 // Number of calls per HTTP status code
+	Latency  Histogram
+}
+
+// MemoryMetrics is a Metrics keeping statistics of every operation in
+// memory. It is safe for concurrent use.
+type MemoryMetrics struct {
+	mu      sync.Mutex
+	buckets []time.Duration
+	stats   map[string]*OperationStats
+}
+
+// NewMemoryMetrics returns a MemoryMetrics whose latency histograms use the
+// given bucket upper bounds, in increasing order. DefaultLatencyBuckets are
+// used if none are given.
+func NewMemoryMetrics(buckets ...time.Duration) *MemoryMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	return &MemoryMetrics{
+		buckets: append([]time.Duration(nil), buckets...),
+		stats:   make(map[string]*OperationStats),
+	}
+}
+
+func (mm *MemoryMetrics) Observe(om *OperationMetrics) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	s, ok := mm.stats[om.Operation]
+	if !ok {
+		s = &OperationStats{Status: make(map[int]int64), Latency: newHistogram(mm.buckets)}
+		mm.stats[om.Operation] = s
+	}
+	s.Count++
+	if om.Err != nil {
+		s.Errors++
+	}
+	s.Retries += int64(om.Retries)
+	s.BytesOut += om.BytesOut
+	s.BytesIn += om.BytesIn
+	s.Status[om.Status]++
+	s.Latency.add(om.Latency)
+}
+
+// Snapshot returns a copy of the statistics of every operation, indexed by
+// operation name.
+func (mm *MemoryMetrics) Snapshot() map[string]OperationStats {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	snapshot := make(map[string]OperationStats, len(mm.stats))
+	for op, s := range mm.stats {
+		c := *s
+		c.Status = make(map[int]int64, len(s.Status))
+		for status, n := range s.Status {
+			c.Status[status] = n
+		}
+		c.Latency.Counts = append([]int64(nil), s.Latency.Counts...)
+		snapshot[op] = c
+	}
+	return snapshot
+}
+
+// Reset clears all statistics.
+func (mm *MemoryMetrics) Reset() {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.stats = make(map[string]*OperationStats)
+}
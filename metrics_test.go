@@ -0,0 +1,81 @@
+package goose
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMemoryMetrics(t *testing.T) {
+	const reply = `{"hits":{"total":0,"hits":[]}}`
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if r.Method == "GET" {
+			fmt.Fprint(w, reply)
+		}
+	}))
+	defer ts.Close()
+
+	mm := NewMemoryMetrics()
+	p := NewRetryPolicy(2)
+	p.InitialBackoff, p.MaxBackoff = time.Millisecond, time.Millisecond
+	u, _ := url.Parse(ts.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithVersion(testVersion), WithIndexCreation(false), WithMetrics(mm), WithRetryPolicy(p))
+
+	dummy := &DummyObject{Id: 1}
+	if err := es.Insert(dummy); err != nil {
+		t.Fatal("Cannot insert dummy object:", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := es.Search(dummy, nil); err != nil {
+			t.Fatal("Search fails:", err)
+		}
+	}
+
+	snapshot := mm.Snapshot()
+	insert, search := snapshot["Insert"], snapshot["Search"]
+	if insert.Count != 1 || insert.Retries != 1 || insert.Errors != 0 || insert.Status[200] != 1 {
+		t.Errorf("wrong Insert stats: %+v", insert)
+	}
+	if insert.BytesOut != int64(len(`{"id":1,"description":"","len":0,"hq":{"lat":0,"lon":0}}`)) {
+		t.Errorf("wrong Insert bytes out: %d", insert.BytesOut)
+	}
+	if search.Count != 2 || search.BytesIn != int64(2*len(reply)) || search.Latency.Count != 2 {
+		t.Errorf("wrong Search stats: %+v", search)
+	}
+
+	mm.Reset()
+	if len(mm.Snapshot()) != 0 {
+		t.Error("Reset did not clear the statistics")
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]time.Duration{10 * time.Millisecond, 100 * time.Millisecond})
+	for _, d := range []time.Duration{time.Millisecond, 5 * time.Millisecond, 50 * time.Millisecond, time.Second} {
+		h.add(d)
+	}
+	if h.Counts[0] != 2 || h.Counts[1] != 1 || h.Counts[2] != 1 {
+		t.Errorf("wrong bucket counts %v", h.Counts)
+	}
+	if q := h.Quantile(0.5); q != 10*time.Millisecond {
+		t.Errorf("wrong median upper bound, expected 10ms, got %v", q)
+	}
+	if q := h.Quantile(0.75); q != 100*time.Millisecond {
+		t.Errorf("wrong 75th percentile upper bound, expected 100ms, got %v", q)
+	}
+	if q := h.Quantile(1); q != time.Second {
+		t.Errorf("wrong max, expected 1s, got %v", q)
+	}
+	if m := h.Mean(); m != 264*time.Millisecond {
+		t.Errorf("wrong mean, expected 264ms, got %v", m)
+	}
+}
@@ -0,0 +1,127 @@
+package goose
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// MultiGetResult is the outcome of the fetch of one of the objects of a
+// MultiGet.
+type MultiGetResult struct {
+	Found bool
+	// Err is the error of the fetch of the object, nil if the object was
+	// fetched or not found. Errors of single documents are *Error values
+	// without HTTP status.
+	Err error
+}
+
+// mgetDoc is a document listed in a _mget request.
+type mgetDoc struct {
+	Index   string `json:"_index,omitempty"`
+	Type    string `json:"_type,omitempty"`
+	Id      string `json:"_id"`
+	Routing string `json:"routing,omitempty"`
+	Parent  string `json:"parent,omitempty"`
+}
+
+// mgetResult is the reply of the _mget API.
+type mgetResult struct {
+	Docs []struct {
+		result
+		Error json.RawMessage `json:"error"`
+	} `json:"docs"`
+}
+
+// gets several elements by key with a single request, filling each one like
+// Get does. The objects can be of different types. The returned results
+// match objects, by position; the error is only set if the request itself
+// failed.
+func (se *ElasticSearch) MultiGet(objects []ElasticObject) ([]MultiGetResult, error) {
+	return se.MultiGetContext(context.Background(), objects)
+}
+
+// MultiGetContext is like MultiGet but uses ctx to bound the request.
+func (se *ElasticSearch) MultiGetContext(ctx context.Context, objects []ElasticObject) ([]MultiGetResult, error) {
+	if len(objects) == 0 {
+		return nil, errors.New("no object to get")
+	}
+	v, err := se.VersionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]mgetDoc, len(objects))
+	for i, object := range objects {
+		docs[i].Id = object.Key()
+		docs[i].Routing, docs[i].Parent = routing(v, object)
+		if v.Dialect() < DIALECT_8X {
+			path, err := buildPath(object)
+			if err != nil {
+				return nil, err
+			}
+			docs[i].Type = strings.TrimSuffix(path, "/")
+		} else if docs[i].Index, err = se.indexName(object); err != nil {
+			return nil, err
+		}
+	}
+	jsondata, err := json.Marshal(M{"docs": docs})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := se.sendRequestAndGetResponse(ctx, "MultiGet", POST, se.basePath+actionMget, bytes.NewReader(jsondata))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res mgetResult
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	if len(res.Docs) != len(objects) {
+		return nil, errors.New("unexpected number of documents in _mget reply")
+	}
+	results := make([]MultiGetResult, len(objects))
+	for i, doc := range res.Docs {
+		object := objects[i]
+		switch {
+		case len(doc.Error) > 0 && string(doc.Error) != "null":
+			body, _ := json.Marshal(M{"_index": doc.Index, "error": doc.Error})
+			results[i].Err = parseError(0, body)
+		case doc.Found:
+			results[i].Found = true
+			if vo, ok := object.(Versioned); ok {
+				vo.SetDocVersion(doc.docVersion())
+			}
+			bj, _ := json.Marshal(doc.Src)
+			results[i].Err = json.Unmarshal(bj, object)
+		}
+	}
+	return results, nil
+}
+
+// gets several elements by key, filling each one like Get does. The returned
+// results match objects, by position.
+func (me *MemoryEngine) MultiGet(objects []ElasticObject) ([]MultiGetResult, error) {
+	return me.MultiGetContext(context.Background(), objects)
+}
+
+// MultiGetContext is like MultiGet but returns ctx.Err() if ctx is done.
+func (me *MemoryEngine) MultiGetContext(ctx context.Context, objects []ElasticObject) ([]MultiGetResult, error) {
+	if len(objects) == 0 {
+		return nil, errors.New("no object to get")
+	}
+	results := make([]MultiGetResult, len(objects))
+	for i, object := range objects {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		found, err := me.GetContext(ctx, object)
+		results[i].Found = found
+		if !IsNotFound(err) {
+			results[i].Err = err
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,74 @@
+package goose
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/gotsunami/goose/goosetest"
+)
+
+// testMultiGet gets objects of several types at once with engine.
+func testMultiGet(t *testing.T, engine SearchEngine) {
+	dummy := &DummyObject{Id: 1, Description: "one", Len: 1.5}
+	for _, object := range []ElasticObject{dummy, &scoreboard{Name: "alice", Points: 3}, &counter{Name: "c", N: 7}} {
+		if err := engine.Insert(object); err != nil {
+			t.Fatalf("Cannot insert: %v", err)
+		}
+	}
+	c := &counter{Name: "c"}
+	objects := []ElasticObject{&DummyObject{Id: 1}, &scoreboard{Name: "alice"}, &DummyObject{Id: 2}, c}
+	results, err := engine.MultiGet(objects)
+	if err != nil {
+		t.Fatalf("Cannot multi get: %v", err)
+	}
+	should := []MultiGetResult{{Found: true}, {Found: true}, {}, {Found: true}}
+	if !reflect.DeepEqual(results, should) {
+		t.Errorf("expected %+v, got %+v", should, results)
+	}
+	if !reflect.DeepEqual(objects[0], dummy) || objects[1].(*scoreboard).Points != 3 || c.N != 7 {
+		t.Errorf("objects not filled: %+v %+v %+v", objects[0], objects[1], c)
+	}
+	if c.DocVersion().Version != 1 {
+		t.Errorf("expected the version 1, got %+v", c.DocVersion())
+	}
+	if _, err := engine.MultiGet(nil); err == nil {
+		t.Error("expected an error without objects")
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	forEachEngine(t, testEngines, func(t *testing.T, s *goosetest.Server, engine SearchEngine) {
+		testMultiGet(t, engine)
+		if s != nil {
+			s.AssertRequestCount(t, "POST", "/"+index+"/_mget", 1)
+		}
+	}, perTypeIndex)
+}
+
+func TestMultiGetErrors(t *testing.T) {
+	for _, reply := range []string{
+		`{"docs":[{"_index":"other","_type":"t","_id":"1","error":"IndexMissingException[[other] missing]"}]}`,
+		`{"docs":[{"_index":"other","_id":"1","error":{"type":"index_not_found_exception","reason":"no such index"}}]}`,
+	} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, reply)
+		}))
+		u, _ := url.Parse(ts.URL + "/" + index)
+		es, _ := NewElasticSearch(u, WithIndexCreation(false), WithVersion("7.10.2"))
+		results, err := es.MultiGet([]ElasticObject{&DummyObject{Id: 1}})
+		if err != nil || len(results) != 1 || results[0].Found || !IsIndexMissing(results[0].Err) {
+			t.Errorf("%s: expected an index missing error, got %+v (%v)", reply, results, err)
+		}
+		if e, ok := asError(results[0].Err); !ok || e.Index != "other" {
+			t.Errorf("%s: expected an error on the index other, got %v", reply, results[0].Err)
+		}
+		if _, err = es.MultiGet([]ElasticObject{&DummyObject{Id: 1}, &DummyObject{Id: 2}}); err == nil {
+			t.Errorf("%s: expected an error on a short reply", reply)
+		}
+		ts.Close()
+	}
+}
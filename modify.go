@@ -0,0 +1,94 @@
+package goose
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"time"
+)
+
+const (
+	defaultModifyAttempts = 5
+	modifyBackoff         = 10 * time.Millisecond // max delay before the first retry of a Modify
+)
+
+// Modify gets the document of object, applies mutate to object and writes it
+// back conditioned on the version it read. If the document was changed in
+// the meantime, the whole cycle is retried with the new document, up to the
+// number of attempts given with WithModifyAttempts (5 by default). The
+// conflict error is returned once attempts are exhausted. Errors returned by
+// mutate abort Modify without writing anything. The document must exist.
+//
+// mutate is called with object, whose fields hold the document read. Fields
+// missing from the document keep the values they had when Modify was called.
+//
+// For example, a counter can be incremented without losing updates:
+//
+//	err := es.Modify(counter, func(o ElasticObject) error {
+//	    o.(*Counter).N++
+//	    return nil
+//	})
+func (se *ElasticSearch) Modify(object Versioned, mutate func(ElasticObject) error) error {
+	return se.ModifyContext(context.Background(), object, mutate)
+}
+
+// ModifyContext is like Modify but uses ctx to bound the requests and the
+// delays between the attempts.
+func (se *ElasticSearch) ModifyContext(ctx context.Context, object Versioned, mutate func(ElasticObject) error) error {
+	attempts := se.modifyAttempts
+	if attempts == 0 {
+		attempts = defaultModifyAttempts
+	}
+	return modify(ctx, se, attempts, object, mutate)
+}
+
+// Modify gets the element of object, applies mutate to object and writes it
+// back conditioned on the version it read, retrying on conflicts up to
+// ModifyAttempts times like ElasticSearch.Modify does.
+func (me *MemoryEngine) Modify(object Versioned, mutate func(ElasticObject) error) error {
+	return me.ModifyContext(context.Background(), object, mutate)
+}
+
+// ModifyContext is like Modify but returns ctx.Err() if ctx is done.
+func (me *MemoryEngine) ModifyContext(ctx context.Context, object Versioned, mutate func(ElasticObject) error) error {
+	attempts := me.ModifyAttempts
+	if attempts == 0 {
+		attempts = defaultModifyAttempts
+	}
+	return modify(ctx, me, attempts, object, mutate)
+}
+
+// modify runs up to attempts read-modify-write cycles of object on engine,
+// until one does not conflict with a concurrent write.
+func modify(ctx context.Context, engine SearchEngine, attempts int, object Versioned, mutate func(ElasticObject) error) error {
+	// the initial values of object, restored before every read
+	var initial reflect.Value
+	if v := reflect.ValueOf(object); v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+		initial = reflect.New(v.Elem().Type()).Elem()
+		initial.Set(v.Elem())
+	}
+	for attempt := 1; ; attempt++ {
+		if initial.IsValid() {
+			reflect.ValueOf(object).Elem().Set(initial)
+		}
+		if _, err := engine.GetContext(ctx, object); err != nil {
+			return err
+		}
+		if err := mutate(object); err != nil {
+			return err
+		}
+		err := engine.InsertContext(ctx, object)
+		if !IsConflict(err) || attempt >= attempts {
+			return err
+		}
+		// spreads the retries of concurrent writers
+		shift := attempt - 1
+		if shift > 5 {
+			shift = 5
+		}
+		d := time.Duration(rand.Int63n(int64(modifyBackoff) << uint(shift)))
+		if err = sleepContext(ctx, d); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package goose
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/gotsunami/goose/goosetest"
+)
+
+type counter struct {
+	VersionTracker
+	Name string `json:"name"`
+	N    int    `json:"n"`
+}
+
+func (c *counter) Key() string {
+	return c.Name
+}
+
+// testModify increments a counter concurrently with engine.
+func testModify(t *testing.T, engine SearchEngine) {
+	if err := engine.Insert(&counter{Name: "hits"}); err != nil {
+		t.Fatalf("Cannot insert: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := engine.Modify(&counter{Name: "hits"}, func(o ElasticObject) error {
+				o.(*counter).N++
+				return nil
+			})
+			if err != nil {
+				t.Errorf("Cannot modify: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	c := &counter{Name: "hits"}
+	if _, err := engine.Get(c); err != nil || c.N != 10 {
+		t.Errorf("lost updates, counter is %d (%v)", c.N, err)
+	}
+}
+
+// testModifyErrors checks the errors of Modify with engine, which makes 3
+// attempts.
+func testModifyErrors(t *testing.T, engine SearchEngine) {
+	c := &counter{Name: "hits"}
+	// a concurrent write at every attempt
+	calls := 0
+	err := engine.Modify(c, func(o ElasticObject) error {
+		calls++
+		return engine.Insert(&counter{Name: "hits", N: 42})
+	})
+	if !IsConflict(err) || calls != 3 {
+		t.Errorf("expected a conflict after 3 attempts, got %v after %d", err, calls)
+	}
+
+	abort := errors.New("abort")
+	if err := engine.Modify(c, func(ElasticObject) error { return abort }); err != abort {
+		t.Errorf("expected the mutate error, got %v", err)
+	}
+	if err := engine.Modify(&counter{Name: "missing"}, func(ElasticObject) error { return nil }); !IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestModify(t *testing.T) {
+	forEachEngine(t, []string{"1.7.5", "7.10.2", memoryEngine}, func(t *testing.T, s *goosetest.Server, engine SearchEngine) {
+		if me, ok := engine.(*MemoryEngine); ok {
+			me.ModifyAttempts = 50
+		}
+		testModify(t, engine)
+		switch e := engine.(type) {
+		case *ElasticSearch:
+			engine, _ = NewElasticSearch(s.IndexURL(index), WithModifyAttempts(3))
+		case *MemoryEngine:
+			e.ModifyAttempts = 3
+		}
+		testModifyErrors(t, engine)
+	}, WithModifyAttempts(50))
+}
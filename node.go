@@ -0,0 +1,278 @@
+package goose
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	actionNodes = "_nodes/http"
+
+	defaultDeadTimeout    = 1 * time.Second
+	defaultMaxDeadTimeout = 5 * time.Minute
+
+	// consecutive 502, 503 and 504 replies of a node marking it dead
+	deadNodeStatusThreshold = 3
+
+	// bound of the background sniffing requests
+	sniffTimeout = 30 * time.Second
+)
+
+// errRequestTimeout is the cause of the cancellation of requests exceeding
+// the timeout of the instance.
+var errRequestTimeout = fmt.Errorf("ES request timeout: %w", context.DeadlineExceeded)
+
+// node is an ES node of the cluster, identified by its base URL
+// (scheme://host:port).
+type node struct {
+	url       string
+	dead      bool
+	failures  int       // consecutive failures
+	deadUntil time.Time // resurrection time of a dead node
+	gateway   int       // consecutive 502, 503 and 504 replies
+}
+
+// nodePool balances requests across the known nodes of the cluster in a
+// round-robin fashion. A failing node is marked dead and is not used anymore
+// until its resurrection time, which grows exponentially with its number of
+// consecutive failures.
+type nodePool struct {
+	mu             sync.Mutex
+	nodes          []*node
+	cur            int
+	deadTimeout    time.Duration
+	maxDeadTimeout time.Duration
+}
+
+func newNodePool(urls []string) *nodePool {
+	p := &nodePool{
+		deadTimeout:    defaultDeadTimeout,
+		maxDeadTimeout: defaultMaxDeadTimeout,
+	}
+	p.set(urls)
+	return p
+}
+
+// next returns the next alive node. Dead nodes whose resurrection time is
+// over are alive again. If all nodes are dead, the one which is about to be
+// resurrected first is returned anyway.
+func (p *nodePool) next() *node {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var first *node
+	for i := 0; i < len(p.nodes); i++ {
+		n := p.nodes[(p.cur+i)%len(p.nodes)]
+		if n.dead && !now.Before(n.deadUntil) {
+			n.dead = false
+		}
+		if !n.dead {
+			p.cur = (p.cur + i + 1) % len(p.nodes)
+			return n
+		}
+		if first == nil || n.deadUntil.Before(first.deadUntil) {
+			first = n
+		}
+	}
+	return first
+}
+
+// markDead excludes n from the pool until its resurrection time.
+func (p *nodePool) markDead(n *node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.kill(n)
+}
+
+// kill marks n dead. p.mu must be held.
+func (p *nodePool) kill(n *node) {
+	n.gateway = 0
+	timeout := p.deadTimeout << uint(n.failures)
+	if timeout > p.maxDeadTimeout || timeout <= 0 {
+		timeout = p.maxDeadTimeout
+	}
+	n.failures++
+	n.dead = true
+	n.deadUntil = time.Now().Add(timeout)
+}
+
+// markAlive resets the failure count of n.
+func (p *nodePool) markAlive(n *node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n.failures = 0
+	n.gateway = 0
+	n.dead = false
+}
+
+// markReply records a reply of n with status. A node replying 502, 503 or
+// 504 to deadNodeStatusThreshold consecutive requests is marked dead; other
+// statuses show that it is alive.
+func (p *nodePool) markReply(n *node, status int) {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+	default:
+		p.markAlive(n)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n.gateway++; n.gateway >= deadNodeStatusThreshold {
+		p.kill(n)
+	}
+}
+
+// set replaces the nodes of the pool. Nodes already known keep their state.
+func (p *nodePool) set(urls []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	known := make(map[string]*node, len(p.nodes))
+	for _, n := range p.nodes {
+		known[n.url] = n
+	}
+	nodes := make([]*node, 0, len(urls))
+	added := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		u = strings.TrimSuffix(u, "/")
+		if added[u] {
+			continue
+		}
+		added[u] = true
+		if n, ok := known[u]; ok {
+			nodes = append(nodes, n)
+		} else {
+			nodes = append(nodes, &node{url: u})
+		}
+	}
+	p.nodes = nodes
+	p.cur = 0
+}
+
+// len returns the number of known nodes.
+func (p *nodePool) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.nodes)
+}
+
+// urls returns the base URL of every known node.
+func (p *nodePool) urls() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	urls := make([]string, len(p.nodes))
+	for i, n := range p.nodes {
+		urls[i] = n.url
+	}
+	return urls
+}
+
+// isDialError returns true if err happened before the request could be sent,
+// in which case it is safe to send it again to another node.
+func isDialError(err error) bool {
+	var oe *net.OpError
+	return errors.As(err, &oe) && oe.Op == "dial"
+}
+
+// isNodeFailure returns true if err, which a request bound to ctx failed
+// with, shows that the node may be down: the connection was reset or closed,
+// or the node did not reply in time. Requests canceled by their caller or
+// exceeding the deadline of its context are not node failures.
+func isNodeFailure(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return context.Cause(ctx) == errRequestTimeout
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Nodes returns the base URLs of the ES nodes known by the instance.
+func (se *ElasticSearch) Nodes() []string {
+	return se.nodes.urls()
+}
+
+// Sniff updates the list of nodes with the HTTP enabled nodes of the
+// cluster, as returned by the _nodes API.
+func (se *ElasticSearch) Sniff() error {
+	return se.SniffContext(context.Background())
+}
+
+// SniffContext is like Sniff but uses ctx to bound the request.
+func (se *ElasticSearch) SniffContext(ctx context.Context) error {
+	se.lastSniff.Store(time.Now().UnixNano())
+	resp, err := se.sendRequestAndGetResponse(ctx, "Sniff", GET, "/"+actionNodes, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		Nodes map[string]struct {
+			HttpAddress string `json:"http_address"` // ES 1.x
+			Http        struct {
+				PublishAddress string `json:"publish_address"`
+			} `json:"http"`
+		} `json:"nodes"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return err
+	}
+	urls := make([]string, 0, len(info.Nodes))
+	for _, n := range info.Nodes {
+		address := n.Http.PublishAddress
+		if address == "" {
+			address = n.HttpAddress
+		}
+		if address = parsePublishAddress(address); address != "" {
+			urls = append(urls, se.scheme+"://"+address)
+		}
+	}
+	if len(urls) == 0 {
+		return errors.New("sniffing found no HTTP enabled node")
+	}
+	se.nodes.set(urls)
+	return nil
+}
+
+// parsePublishAddress extracts host:port from a node's publish address, which
+// is formatted as inet[hostname/ip:port] in ES 1.x, ip:port or hostname/ip:port
+// in later versions.
+func parsePublishAddress(address string) string {
+	address = strings.TrimPrefix(address, "inet[")
+	address = strings.TrimSuffix(address, "]")
+	if i := strings.LastIndex(address, "/"); i >= 0 {
+		address = address[i+1:]
+	}
+	return address
+}
+
+// sniffIfNeeded refreshes the list of nodes in the background when the
+// sniffing interval is over.
+func (se *ElasticSearch) sniffIfNeeded() {
+	if se.sniffInterval <= 0 {
+		return
+	}
+	last := se.lastSniff.Load()
+	if time.Since(time.Unix(0, last)) < se.sniffInterval {
+		return
+	}
+	if !se.lastSniff.CompareAndSwap(last, time.Now().UnixNano()) {
+		return // already sniffing
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sniffTimeout)
+		defer cancel()
+		se.SniffContext(ctx)
+	}()
+}
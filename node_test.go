@@ -0,0 +1,161 @@
+package goose
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNodePool(t *testing.T) {
+	p := newNodePool([]string{"http://a:9200/", "http://b:9200", "http://c:9200"})
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, p.next().url)
+	}
+	should := "http://a:9200 http://b:9200 http://c:9200 http://a:9200"
+	if r := strings.Join(got, " "); r != should {
+		t.Errorf("wrong round-robin. Expected\n%v\ngot\n%v", should, r)
+	}
+
+	b := p.nodes[1]
+	p.markDead(b)
+	for i := 0; i < 3; i++ {
+		if n := p.next(); n == b {
+			t.Error("dead node returned by the pool")
+		}
+	}
+	// resurrection
+	b.deadUntil = time.Now().Add(-time.Second)
+	found := false
+	for i := 0; i < 3; i++ {
+		if p.next() == b {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("dead node not resurrected after its timeout")
+	}
+
+	// all dead, the first one to be resurrected is returned
+	for _, n := range p.nodes {
+		p.markDead(n)
+	}
+	p.markDead(p.nodes[0])
+	if n := p.next(); n == p.nodes[0] {
+		t.Error("the pool returned the node with the longest dead timeout")
+	}
+}
+
+func TestNodeFailover(t *testing.T) {
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer ts.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	u, _ := url.Parse(dead.URL + "/" + index)
+	es, err := NewElasticSearch(u, WithNodes(ts.URL), WithIndexCreation(false))
+	if err != nil {
+		t.Fatal("Cannot create client:", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err = es.OpenIndex(); err != nil {
+			t.Error("Cannot open index:", err)
+		}
+	}
+	if hits != 4 {
+		t.Errorf("expected 4 requests on the alive node, got %d", hits)
+	}
+}
+
+// nodes timing out or replying 503 are put aside like unreachable ones
+func TestNodeFailures(t *testing.T) {
+	var hits int32
+	alive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer alive.Close()
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hung.Close()
+
+	u, _ := url.Parse(unavailable.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithNodes(alive.URL), WithIndexCreation(false))
+	failures := 0
+	for i := 0; i < 10; i++ {
+		if es.OpenIndex() != nil {
+			failures++
+		}
+	}
+	if failures != deadNodeStatusThreshold || atomic.LoadInt32(&hits) != int32(10-failures) {
+		t.Errorf("expected %d failures before the node is put aside, got %d", deadNodeStatusThreshold, failures)
+	}
+
+	atomic.StoreInt32(&hits, 0)
+	u, _ = url.Parse(hung.URL + "/" + index)
+	es, _ = NewElasticSearch(u, WithNodes(alive.URL), WithIndexCreation(false), WithTimeout(50*time.Millisecond))
+	for i := 0; i < 4; i++ {
+		es.OpenIndex()
+	}
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Errorf("expected 3 requests on the alive node once the hung one timed out, got %d", n)
+	}
+
+	// requests canceled by the caller do not put the node aside
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	u, _ = url.Parse(hung.URL + "/" + index)
+	es, _ = NewElasticSearch(u, WithIndexCreation(false))
+	es.OpenIndexContext(ctx)
+	if n := es.nodes.next(); n.dead {
+		t.Error("node put aside after the deadline of the caller")
+	}
+}
+
+func TestSniff(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	data1 := httptest.NewServer(handler)
+	defer data1.Close()
+	data2 := httptest.NewServer(handler)
+	defer data2.Close()
+	seed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+actionNodes {
+			t.Errorf("unexpected request %s on seed node", r.URL.Path)
+		}
+		fmt.Fprintf(w, `{"nodes":{"n1":{"http":{"publish_address":"localhost/%s"}},"n2":{"http_address":"inet[/%s]"}}}`,
+			strings.TrimPrefix(data1.URL, "http://"), strings.TrimPrefix(data2.URL, "http://"))
+	}))
+	defer seed.Close()
+
+	u, _ := url.Parse(seed.URL + "/" + index)
+	es, err := NewElasticSearch(u, WithSniffing(time.Hour), WithIndexCreation(false))
+	if err != nil {
+		t.Fatal("Cannot sniff nodes:", err)
+	}
+	nodes := es.Nodes()
+	sort.Strings(nodes)
+	should := []string{data1.URL, data2.URL}
+	sort.Strings(should)
+	if !reflect.DeepEqual(nodes, should) {
+		t.Errorf("wrong sniffed nodes. Expected %v, got %v", should, nodes)
+	}
+	if err = es.OpenIndex(); err != nil {
+		t.Error("Cannot open index on sniffed node:", err)
+	}
+}
@@ -0,0 +1,236 @@
+package goose
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Option configures an ElasticSearch instance. Options are applied in order
+// by NewElasticSearch.
+type Option func(*ElasticSearch) error
+
+// WithHttpClient makes the instance send its requests with client instead
+// of its own pooling HTTP client.
+func WithHttpClient(client *http.Client) Option {
+	return func(se *ElasticSearch) error {
+		if client == nil {
+			return errors.New("nil HTTP client")
+		}
+		se.client = client
+		return nil
+	}
+}
+
+// WithTransport makes the instance send its requests through rt. The HTTP
+// client given with WithHttpClient, if any, is left untouched: a copy of it
+// is used instead.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(se *ElasticSearch) error {
+		if rt == nil {
+			return errors.New("nil HTTP transport")
+		}
+		se.transport = rt
+		return nil
+	}
+}
+
+// WithTimeout bounds every request to d. It applies on top of the deadline
+// of the context given to the Context variants, the earliest one wins.
+// A zero duration disables the default timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(se *ElasticSearch) error {
+		if d < 0 {
+			return errors.New("negative timeout")
+		}
+		se.timeout = d
+		return nil
+	}
+}
+
+// WithHeader adds a header sent along with every request.
+func WithHeader(key, value string) Option {
+	return func(se *ElasticSearch) error {
+		se.header.Add(key, value)
+		return nil
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent along with every request.
+func WithUserAgent(ua string) Option {
+	return func(se *ElasticSearch) error {
+		se.header.Set("User-Agent", ua)
+		return nil
+	}
+}
+
+// WithIndexCreation defines whether NewElasticSearch creates the index if it
+// does not exist yet (the default) or does not send any request at all.
+func WithIndexCreation(create bool) Option {
+	return func(se *ElasticSearch) error {
+		se.createIndex = create
+		return nil
+	}
+}
+
+// WithNodes adds seed nodes to the host of the URL given to NewElasticSearch.
+// Each url must be the base URL of a node, i.e http://host:9200.
+func WithNodes(urls ...string) Option {
+	return func(se *ElasticSearch) error {
+		for _, u := range urls {
+			nu, err := url.Parse(u)
+			if err != nil {
+				return err
+			}
+			if nu.Scheme == "" || nu.Host == "" {
+				return errors.New("invalid node URL " + u)
+			}
+			se.seeds = append(se.seeds, nu.Scheme+"://"+nu.Host)
+		}
+		return nil
+	}
+}
+
+// WithSniffing makes NewElasticSearch discover the nodes of the cluster with
+// the _nodes API. The list of nodes is then refreshed in the background every
+// interval.
+func WithSniffing(interval time.Duration) Option {
+	return func(se *ElasticSearch) error {
+		if interval <= 0 {
+			return errors.New("sniffing interval must be positive")
+		}
+		se.sniffInterval = interval
+		return nil
+	}
+}
+
+// WithDeadNodeTimeout defines how long a failing node is put aside. The
+// timeout doubles with each consecutive failure of the node, up to max.
+func WithDeadNodeTimeout(timeout, max time.Duration) Option {
+	return func(se *ElasticSearch) error {
+		if timeout <= 0 || max < timeout {
+			return errors.New("invalid dead node timeouts")
+		}
+		se.nodes.deadTimeout = timeout
+		se.nodes.maxDeadTimeout = max
+		return nil
+	}
+}
+
+// WithRetryPolicy makes the instance retry the requests failing because of a
+// transient error according to p.
+func WithRetryPolicy(p *RetryPolicy) Option {
+	return func(se *ElasticSearch) error {
+		if p != nil && p.MaxBackoff < p.InitialBackoff {
+			return errors.New("invalid retry backoff")
+		}
+		se.retry = p
+		return nil
+	}
+}
+
+// WithCircuitBreaker makes the instance fail fast with a *CircuitOpenError
+// instead of sending requests while the circuits of cb are open. Requests
+// rejected by an open circuit are not retried.
+func WithCircuitBreaker(cb *CircuitBreaker) Option {
+	return func(se *ElasticSearch) error {
+		if cb != nil && cb.FailureThreshold < 1 {
+			return errors.New("circuit breaker failure threshold must be positive")
+		}
+		se.breaker = cb
+		return nil
+	}
+}
+
+// WithModifyAttempts sets the maximum number of read-modify-write cycles
+// made by Modify when the document is changed concurrently, 5 by default.
+func WithModifyAttempts(n int) Option {
+	return func(se *ElasticSearch) error {
+		if n < 1 {
+			return errors.New("modify attempts must be positive")
+		}
+		se.modifyAttempts = n
+		return nil
+	}
+}
+
+// WithRefresh sets the default refresh policy of writes, REFRESH_NONE by
+// default. Updates and bulks given another policy with the SetRefresh
+// methods of UpdateBuilder and BulkBuilder use theirs.
+func WithRefresh(p RefreshPolicy) Option {
+	return func(se *ElasticSearch) error {
+		switch p {
+		case REFRESH_NONE, REFRESH_IMMEDIATE, REFRESH_WAIT_FOR:
+			se.refresh = p
+			return nil
+		}
+		return errors.New("unknown refresh policy " + string(p))
+	}
+}
+
+// WithMaxInFlight limits the number of concurrent requests sent by the
+// instance to n. A request holds its slot until its response is read, and
+// additional requests wait for a slot to be released. By default, the
+// number of concurrent requests is not limited.
+func WithMaxInFlight(n int) Option {
+	return func(se *ElasticSearch) error {
+		if n < 0 {
+			return errors.New("negative max in-flight requests")
+		}
+		se.maxInFlight = n
+		return nil
+	}
+}
+
+// WithTracer makes the instance notify t of every request it sends.
+//
+// For example, the following snippet logs every request as a curl command:
+//
+//	es, err := NewElasticSearch(u, WithTracer(&LogTracer{Curl: true}))
+func WithTracer(t Tracer) Option {
+	return func(se *ElasticSearch) error {
+		se.tracer = t
+		return nil
+	}
+}
+
+// WithMetrics makes the instance report the metrics of every API call to m.
+func WithMetrics(m Metrics) Option {
+	return func(se *ElasticSearch) error {
+		se.metrics = m
+		return nil
+	}
+}
+
+// WithVersion sets the version of the ES cluster, i.e 7.10.2, instead of
+// detecting it.
+func WithVersion(version string) Option {
+	return func(se *ElasticSearch) error {
+		v, err := ParseVersion(version)
+		if err != nil {
+			return err
+		}
+		se.version = &v
+		return nil
+	}
+}
+
+// WithIndexResolver makes the instance store the objects of each type in the
+// index named by resolve(typ) on ES 8.x and later, where an index cannot
+// hold several types. typ is the type of the objects in the paths of older
+// versions, i.e the package path and the lowercased name of their Go type, or
+// the path returned by their BuildPath method without its trailing slash.
+// The indices are created by ES with the first document written to them.
+//
+// Without a resolver, the objects of the first type used on ES 8.x are
+// stored in the index of the instance and other types are refused.
+func WithIndexResolver(resolve func(typ string) string) Option {
+	return func(se *ElasticSearch) error {
+		if resolve == nil {
+			return errors.New("nil index resolver")
+		}
+		se.indexResolver = resolve
+		return nil
+	}
+}
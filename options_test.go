@@ -0,0 +1,96 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestWithoutIndexCreation(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	if _, err := NewElasticSearch(u, WithIndexCreation(false)); err != nil {
+		t.Fatal("Cannot create client:", err)
+	}
+	if calls != 0 {
+		t.Errorf("expected no request at construction time, got %d", calls)
+	}
+}
+
+func TestWithHeaders(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	es, err := NewElasticSearch(u, WithIndexCreation(false),
+		WithUserAgent("goose-test"), WithHeader("X-Opaque-Id", "42"))
+	if err != nil {
+		t.Fatal("Cannot create client:", err)
+	}
+	if err = es.OpenIndex(); err != nil {
+		t.Fatal("Cannot open index:", err)
+	}
+	if ua := header.Get("User-Agent"); ua != "goose-test" {
+		t.Errorf("wrong user agent. Expected goose-test, got %q", ua)
+	}
+	if id := header.Get("X-Opaque-Id"); id != "42" {
+		t.Errorf("wrong X-Opaque-Id header. Expected 42, got %q", id)
+	}
+}
+
+func TestWithTransport(t *testing.T) {
+	var paths []string
+	rt := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		return nil, errors.New("unreachable")
+	})
+	client := &http.Client{}
+
+	u, _ := url.Parse(uri + index)
+	_, err := NewElasticSearch(u, WithVersion(testVersion), WithHttpClient(client), WithTransport(rt))
+	if err == nil {
+		t.Error("expected transport error")
+	}
+	if len(paths) != 1 || paths[0] != "GET /"+index+"/"+actionStats {
+		t.Errorf("requests did not go through the transport: %v", paths)
+	}
+	if client.Transport != nil {
+		t.Error("WithTransport modified the given HTTP client")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	hang := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(hang)
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	_, err := NewElasticSearch(u, WithTimeout(50*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded error, got %v", err)
+	}
+}
@@ -431,10 +431,122 @@ func (qb *QueryBuilder) ForceToJSON() (string, error) {
 	q = strings.Replace(q, `,"facets":null`, "", 1)
 	q = strings.Replace(q, `,"facets":{}`, "", 1)
 
-	//	fmt.Println(q)
 	return q, nil
 }
 
+// ToJSONDialect is like ToJSON but returns a query suitable for the ES
+// versions of dialect d. ToJSON returns queries for ES 1.x.
+func (qb *QueryBuilder) ToJSONDialect(d Dialect) (string, error) {
+	if len(qb.warnings) > 0 {
+		return "", errors.New("ToJSON() refuses to marshal queries with warnings!")
+	}
+	return qb.ForceToJSONDialect(d)
+}
+
+// ForceToJSONDialect is like ForceToJSON but returns a query suitable for the
+// ES versions of dialect d.
+//
+// Since ES 2.x, the "filtered" query is replaced by a "bool" query with a
+// "filter" clause and facets are replaced by aggregations. For example, the
+// following snippet
+//  qb := NewQueryBuilder().SetTerm("name", "montre").SetTermFacet("names", "name", 5, nil)
+//  r, err := qb.ToJSONDialect(DIALECT_5X)
+// will expand to
+//  {
+//      "size": 10,
+//      "query": {
+//          "bool": {
+//              "must": [
+//                  {"term": {"name": "montre"}}
+//              ]
+//          }
+//      },
+//      "aggs": { "names": {"terms": {"field": "name", "size": 5} } }
+//  }
+func (qb *QueryBuilder) ForceToJSONDialect(d Dialect) (string, error) {
+	if d == DIALECT_1X {
+		return qb.ForceToJSON()
+	}
+	bq := qb.Query.Filtered.Query.Bool
+	clauses := make(M)
+	if len(bq.Must) > 0 {
+		clauses["must"] = convertClauses(bq.Must, d)
+	}
+	if len(bq.Should) > 0 {
+		clauses["should"] = convertClauses(bq.Should, d)
+	}
+	var filters []M
+	f := qb.Query.Filtered.Filter
+	if f.GeoBoundingBox != nil {
+		filters = append(filters, M{"geo_bounding_box": f.GeoBoundingBox})
+	}
+	if f.GeoDistance != nil {
+		filters = append(filters, M{"geo_distance": f.GeoDistance})
+	}
+	if f.GeoPolygon != nil {
+		filters = append(filters, M{"geo_polygon": f.GeoPolygon})
+	}
+	if len(filters) > 0 {
+		clauses["filter"] = filters
+		// should clauses are optional along with filters if there is no must
+		if len(bq.Must) == 0 && len(bq.Should) > 0 {
+			clauses["minimum_should_match"] = 1
+		}
+	}
+	query := M{"match_all": M{}}
+	if len(clauses) > 0 {
+		query = M{"bool": clauses}
+	}
+	var aggs map[string]M
+	if len(qb.Facets) > 0 {
+		aggs = make(map[string]M, len(qb.Facets))
+		for name, facet := range qb.Facets {
+			aggs[name] = M{"terms": facet.Terms}
+		}
+	}
+	b, err := json.Marshal(struct {
+		From  int          `json:"from,omitempty"`
+		Size  int          `json:"size,omitempty"`
+		Query M            `json:"query"`
+		Sort  []M          `json:"sort,omitempty"`
+		Aggs  map[string]M `json:"aggs,omitempty"`
+	}{qb.From, qb.Size, query, qb.Sort, aggs})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// convertClauses rewrites the clauses removed in dialect d: phrase match
+// queries are match_phrase queries since ES 5.x.
+func convertClauses(clauses []M, d Dialect) []M {
+	if d < DIALECT_5X {
+		return clauses
+	}
+	converted := make([]M, len(clauses))
+	for i, c := range clauses {
+		converted[i] = c
+		match, ok := c["match"].(M)
+		if !ok {
+			continue
+		}
+		for field, v := range match {
+			params, ok := v.(map[string]string)
+			if !ok || params["type"] != "phrase" {
+				continue
+			}
+			phrase := make(map[string]string, len(params))
+			for k, p := range params {
+				if k != "type" {
+					phrase[k] = p
+				}
+			}
+			converted[i] = M{"match_phrase": M{field: phrase}}
+		}
+	}
+	return converted
+}
+
 // Checksum computes a SHA1 sum of the query builder's json
 // string representation. Queries with the same search criteria
 // have the same checksum.
@@ -0,0 +1,42 @@
+package goose
+
+import (
+	"net/url"
+)
+
+// RefreshPolicy tells when the changes made by a write become visible to
+// searches. By default, ES makes them visible at the next periodic refresh
+// of the index, every second. The zero value selects the default policy of
+// the instance, set with WithRefresh.
+type RefreshPolicy string
+
+// Refresh policies of writes.
+const (
+	REFRESH_NONE      RefreshPolicy = "false"    // wait for the periodic refresh
+	REFRESH_IMMEDIATE RefreshPolicy = "true"     // refresh the shards written to at once
+	REFRESH_WAIT_FOR  RefreshPolicy = "wait_for" // reply once the changes are visible, since ES 5.x
+)
+
+// refreshPolicy returns p, or the default policy of se if p is empty.
+func (se *ElasticSearch) refreshPolicy(p RefreshPolicy) RefreshPolicy {
+	if p == "" {
+		p = se.refresh
+	}
+	if p == "" {
+		p = REFRESH_NONE
+	}
+	return p
+}
+
+// refreshParams adds to params the refresh parameter of a write with the
+// policy p on ES version v. REFRESH_WAIT_FOR is replaced with an immediate
+// refresh before ES 5.x, or if waitFor is false.
+func (se *ElasticSearch) refreshParams(v Version, p RefreshPolicy, params url.Values, waitFor bool) {
+	p = se.refreshPolicy(p)
+	if p == REFRESH_WAIT_FOR && (v.Major < 5 || !waitFor) {
+		p = REFRESH_IMMEDIATE
+	}
+	if p != REFRESH_NONE {
+		params.Set("refresh", string(p))
+	}
+}
@@ -0,0 +1,145 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gotsunami/goose/goosetest"
+)
+
+func TestRefreshParams(t *testing.T) {
+	tests := []struct {
+		version  string
+		instance RefreshPolicy // default policy of the instance
+		policy   RefreshPolicy // policy of the write
+		waitFor  bool
+		should   string
+	}{
+		{"1.7.5", "", "", true, ""},
+		{"1.7.5", "", REFRESH_NONE, true, ""},
+		{"1.7.5", "", REFRESH_IMMEDIATE, true, "refresh=true"},
+		{"1.7.5", REFRESH_WAIT_FOR, "", true, "refresh=true"},
+		{"7.10.2", REFRESH_WAIT_FOR, "", true, "refresh=wait_for"},
+		{"7.10.2", "", REFRESH_WAIT_FOR, false, "refresh=true"},
+		{"7.10.2", REFRESH_WAIT_FOR, REFRESH_NONE, true, ""},
+		{"8.11.1", REFRESH_NONE, REFRESH_IMMEDIATE, true, "refresh=true"},
+	}
+	for _, test := range tests {
+		v, _ := ParseVersion(test.version)
+		es := &ElasticSearch{refresh: test.instance}
+		params := make(url.Values)
+		if es.refreshParams(v, test.policy, params, test.waitFor); params.Encode() != test.should {
+			t.Errorf("%s %q %q: expected %q, got %q", test.version, test.instance, test.policy, test.should, params.Encode())
+		}
+	}
+}
+
+func TestWithRefresh(t *testing.T) {
+	for _, bad := range []RefreshPolicy{"", "soon"} {
+		if err := WithRefresh(bad)(&ElasticSearch{}); err == nil {
+			t.Errorf("expected an error for the refresh policy %q", bad)
+		}
+	}
+	es := new(ElasticSearch)
+	if err := WithRefresh(REFRESH_IMMEDIATE)(es); err != nil || es.refresh != REFRESH_IMMEDIATE {
+		t.Errorf("refresh policy not set: %q, %v", es.refresh, err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	forEachEngine(t, testEngines, func(t *testing.T, s *goosetest.Server, engine SearchEngine) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := engine.RefreshContext(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+		es, ok := engine.(*ElasticSearch)
+		if !ok {
+			me := engine.(*MemoryEngine)
+			dummy := &DummyObject{Id: 1}
+			if _, err := me.Bulk(NewBulkBuilder().AddIndex(dummy).AddDelete(dummy).SetRefresh(REFRESH_WAIT_FOR)); err != nil {
+				t.Error("Cannot bulk:", err)
+			}
+			if err := me.Refresh(); err != nil {
+				t.Error("Cannot refresh:", err)
+			}
+			return
+		}
+		v, _ := es.Version()
+		d := v.Dialect()
+		query := "?refresh=wait_for"
+		if v.Major < 5 {
+			query = "?refresh=true"
+		}
+		dummy := &DummyObject{Id: 1, Description: "Dummy object 1"}
+		path, _ := es.docPath(d, dummy)
+		upath, _ := es.updatePath(d, dummy)
+		tpath, _ := es.typePath(d, dummy)
+
+		// the default policy of the instance
+		if err := es.Insert(dummy); err != nil {
+			t.Fatalf("Cannot insert: %v", err)
+		}
+		s.AssertRequested(t, "PUT", path+query)
+		if n, err := es.Count(dummy); n != 1 || err != nil {
+			t.Errorf("expected 1 element, got %d (%v)", n, err)
+		}
+		if _, err := es.Bulk(NewBulkBuilder().AddIndex(&DummyObject{Id: 2})); err != nil {
+			t.Errorf("Cannot bulk: %v", err)
+		}
+		s.AssertRequested(t, "POST", "/"+index+"/_bulk"+query)
+
+		// the policies of the writes
+		dummy.Len = 2
+		if err := es.UpdateWith(dummy, NewUpdateBuilder().SetRefresh(REFRESH_IMMEDIATE)); err != nil {
+			t.Errorf("Cannot update: %v", err)
+		}
+		s.AssertRequested(t, "POST", upath+"?refresh=true")
+		if _, err := es.Bulk(NewBulkBuilder().AddIndex(&DummyObject{Id: 3}).SetRefresh(REFRESH_IMMEDIATE)); err != nil {
+			t.Errorf("Cannot bulk: %v", err)
+		}
+		s.AssertRequested(t, "POST", "/"+index+"/_bulk?refresh=true")
+		s.ClearRequests()
+		if _, err := es.Import(strings.NewReader(`{"id":4}`), dummy, ImportConfig{IdField: "id", Refresh: REFRESH_NONE}); err != nil {
+			t.Errorf("Cannot import: %v", err)
+		}
+		s.AssertRequested(t, "POST", tpath+"_bulk")
+		s.AssertNotRequested(t, "POST", tpath+"_bulk"+query)
+		if err := es.Delete(dummy); err != nil {
+			t.Errorf("Cannot delete: %v", err)
+		}
+		s.AssertRequested(t, "DELETE", path+query)
+		s.ClearRequests()
+		if _, err := es.Bulk(NewBulkBuilder().AddDelete(&DummyObject{Id: 3}).SetRefresh(REFRESH_NONE)); err != nil {
+			t.Errorf("Cannot bulk: %v", err)
+		}
+		s.AssertRequested(t, "POST", "/"+index+"/_bulk")
+		s.AssertNotRequested(t, "POST", "/"+index+"/_bulk"+query)
+
+		s.ClearRequests()
+		if _, err := es.DeleteByQuery(dummy, NewQueryBuilder().SetTerm("id", "2")); err != nil {
+			t.Errorf("Cannot delete by query: %v", err)
+		}
+		if v.Major < 5 {
+			s.AssertRequested(t, "DELETE", tpath+actionQuery)
+			s.AssertRequested(t, "POST", "/"+index+"/_refresh")
+		} else {
+			s.AssertRequested(t, "POST", tpath+actionDeleteByQuery+"?refresh=true")
+		}
+
+		bp := es.NewBulkProcessor(BulkProcessorConfig{Refresh: REFRESH_IMMEDIATE})
+		bp.AddIndex(&DummyObject{Id: 5})
+		if err := bp.Close(); err != nil {
+			t.Errorf("Cannot close bulk processor: %v", err)
+		}
+		s.AssertRequested(t, "POST", "/"+index+"/_bulk?refresh=true")
+
+		if err := es.Refresh(); err != nil {
+			t.Errorf("Cannot refresh: %v", err)
+		}
+		s.AssertRequested(t, "POST", "/"+index+"/_refresh")
+	}, WithRefresh(REFRESH_WAIT_FOR))
+}
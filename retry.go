@@ -0,0 +1,105 @@
+package goose
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy defines how requests failing because of a transient error are
+// sent again. Requests are retried with an exponential backoff: the n-th
+// retry waits InitialBackoff * 2^(n-1), capped to MaxBackoff, minus a random
+// part of at most Jitter times that delay.
+//
+// Requests which may have been executed by ES (i.e connection reset or
+// 503 errors) are only replayed if they are idempotent or if
+// RetryNonIdempotent is set. GET, PUT and DELETE requests are idempotent,
+// but for create-only writes and writes conditioned on a document version,
+// which would fail with a conflict once replayed. Requests which cannot have
+// been executed (connection refused, 429 rejected execution) are always
+// retried.
+type RetryPolicy struct {
+	MaxAttempts        int           // Max number of attempts, including the first one
+	InitialBackoff     time.Duration // Delay before the first retry
+	MaxBackoff         time.Duration // Upper limit of the delay between two attempts
+	Jitter             float64       // Randomization factor of the delays, from 0 to 1
+	RetryStatus        []int         // HTTP status codes considered as transient
+	RetryNonIdempotent bool          // Replays non idempotent requests on ambiguous failures
+
+	// RetryError reports whether an error returned by the HTTP client is
+	// transient. If nil, every error but context cancellations is.
+	RetryError func(error) bool
+}
+
+// NewRetryPolicy returns a RetryPolicy making up to attempts attempts, with
+// a backoff from 100ms to 10s, retrying 429, 502, 503 and 504 HTTP errors.
+func NewRetryPolicy(attempts int) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    attempts,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Jitter:         0.5,
+		RetryStatus: []int{
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// retryable reports whether the attempt-th attempt of a request, which got
+// resp or err, must be retried. idempotent tells whether the request can be
+// replayed once executed.
+func (p *RetryPolicy) retryable(idempotent bool, resp *http.Response, err error, attempt int) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	idempotent = idempotent || p.RetryNonIdempotent
+	if err != nil {
+		if IsCircuitOpen(err) {
+			return false
+		}
+		if isDialError(err) {
+			return true
+		}
+		if p.RetryError != nil && !p.RetryError(err) {
+			return false
+		}
+		return idempotent
+	}
+	for _, status := range p.RetryStatus {
+		if resp.StatusCode == status {
+			// ES rejected the request, nothing was executed
+			return resp.StatusCode == http.StatusTooManyRequests || idempotent
+		}
+	}
+	return false
+}
+
+// backoff returns the delay to wait after the attempt-th attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MaxBackoff
+	if attempt <= 30 {
+		if e := p.InitialBackoff << uint(attempt-1); e > 0 && e < d {
+			d = e
+		}
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// sleepContext waits for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
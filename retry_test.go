@@ -0,0 +1,137 @@
+package goose
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// returns a server replying status to the first failures requests
+func newFlakyServer(status, failures int, calls *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if *calls <= failures {
+			w.WriteHeader(status)
+		}
+	}))
+}
+
+func newRetryPolicy() *RetryPolicy {
+	p := NewRetryPolicy(3)
+	p.InitialBackoff = time.Millisecond
+	p.MaxBackoff = 5 * time.Millisecond
+	return p
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	calls := 0
+	ts := newFlakyServer(http.StatusServiceUnavailable, 2, &calls)
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	if _, err := NewElasticSearch(u, WithVersion(testVersion), WithRetryPolicy(newRetryPolicy())); err != nil {
+		t.Error("GET request was not retried:", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestRetryMaxAttempts(t *testing.T) {
+	calls := 0
+	ts := newFlakyServer(http.StatusServiceUnavailable, 5, &calls)
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	if _, err := NewElasticSearch(u, WithVersion(testVersion), WithRetryPolicy(newRetryPolicy())); err == nil {
+		t.Error("expected an error after the last attempt")
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestRetryNonIdempotent(t *testing.T) {
+	calls := 0
+	ts := newFlakyServer(http.StatusServiceUnavailable, 1, &calls)
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithRetryPolicy(newRetryPolicy()), WithIndexCreation(false))
+	if err := es.OpenIndex(); err == nil {
+		t.Error("POST request was replayed")
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+
+	// rejected requests were not executed and can be replayed
+	calls = 0
+	ts429 := newFlakyServer(http.StatusTooManyRequests, 1, &calls)
+	defer ts429.Close()
+
+	u, _ = url.Parse(ts429.URL + "/" + index)
+	es, _ = NewElasticSearch(u, WithRetryPolicy(newRetryPolicy()), WithIndexCreation(false))
+	if err := es.OpenIndex(); err != nil {
+		t.Error("rejected POST request was not retried:", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
+
+// create-only and conditioned writes fail with a conflict once replayed
+func TestRetryConditionalWrites(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(es *ElasticSearch) error
+		calls int
+	}{
+		{"insert", func(es *ElasticSearch) error { return es.Insert(&DummyObject{Id: 1}) }, 2},
+		{"create", func(es *ElasticSearch) error { return es.Create(&DummyObject{Id: 1}) }, 1},
+		{"conditioned insert", func(es *ElasticSearch) error {
+			v := &versionedObject{Id: 1}
+			v.SetDocVersion(DocVersion{SeqNo: 3, PrimaryTerm: 1})
+			return es.Insert(v)
+		}, 1},
+		{"conditioned delete", func(es *ElasticSearch) error {
+			v := &versionedObject{Id: 1}
+			v.SetDocVersion(DocVersion{Version: 2})
+			return es.Delete(v)
+		}, 1},
+	}
+	for _, test := range tests {
+		calls := 0
+		ts := newFlakyServer(http.StatusServiceUnavailable, 1, &calls)
+		u, _ := url.Parse(ts.URL + "/" + index)
+		es, _ := NewElasticSearch(u, WithVersion("7.10.2"), WithRetryPolicy(newRetryPolicy()), WithIndexCreation(false))
+		test.write(es)
+		if calls != test.calls {
+			t.Errorf("%s: expected %d calls, got %d", test.name, test.calls, calls)
+		}
+		ts.Close()
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := NewRetryPolicy(10)
+	p.Jitter = 0
+	should := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}
+	for i, d := range should {
+		if b := p.backoff(i + 1); b != d {
+			t.Errorf("wrong backoff for attempt %d. Expected %v, got %v", i+1, d, b)
+		}
+	}
+	if b := p.backoff(100); b != p.MaxBackoff {
+		t.Errorf("backoff should be capped to %v, got %v", p.MaxBackoff, b)
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if b := p.backoff(1); b < 50*time.Millisecond || b > 100*time.Millisecond {
+			t.Fatalf("backoff with jitter out of range: %v", b)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package goose
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Router is implemented by the objects routed to a shard with a custom
+// routing value instead of their key, i.e to store the documents of a
+// customer together. The routing value is given to the requests writing or
+// getting the object, and to the searches, counts and deletions by query
+// made with a Router object whose routing value is not empty, which then
+// only look at the shard of this value.
+//
+// The MemoryEngine, which has no shards, ignores routing values.
+type Router interface {
+	ElasticObject
+	Routing() string
+}
+
+// Parented is implemented by the child documents of parent/child
+// relations, which are stored on the shard of their parent. Before ES 6.x,
+// the parent id is given with the parent parameter, the relation being
+// defined by the _parent field of the mapping of the child type. Since ES
+// 6.x, the relation is a join field of the documents, and the parent id is
+// only used as the routing value, unless the object is a Router too.
+type Parented interface {
+	ElasticObject
+	Parent() string
+}
+
+// routing returns the routing value and the parent id of object for ES
+// version v. The parent id is empty since ES 6.x, where it becomes the
+// routing value if there is none.
+func routing(v Version, object ElasticObject) (routing, parent string) {
+	if r, ok := object.(Router); ok {
+		routing = r.Routing()
+	}
+	if p, ok := object.(Parented); ok {
+		parent = p.Parent()
+	}
+	if v.Major >= 6 {
+		if routing == "" {
+			routing = parent
+		}
+		parent = ""
+	}
+	return routing, parent
+}
+
+// routingParams adds to params the routing and parent parameters of the
+// requests on object for ES version v.
+func routingParams(v Version, object ElasticObject, params url.Values) {
+	r, p := routing(v, object)
+	if r != "" {
+		params.Set("routing", r)
+	}
+	if p != "" {
+		params.Set("parent", p)
+	}
+}
+
+// searchRouting returns the routing parameter of the searches made with
+// object, an empty string if there is none. Children are searched on the
+// shard of their parent.
+func searchRouting(object ElasticObject) string {
+	var r string
+	if ro, ok := object.(Router); ok {
+		r = ro.Routing()
+	}
+	if p, ok := object.(Parented); ok && r == "" {
+		r = p.Parent()
+	}
+	if r == "" {
+		return ""
+	}
+	return url.Values{"routing": {r}}.Encode()
+}
+
+// addQuery appends the encoded query parameters query, if any, to path
+// which may already have some.
+func addQuery(path, query string) string {
+	switch {
+	case query == "":
+		return path
+	case strings.Contains(path, "?"):
+		return path + "&" + query
+	}
+	return path + "?" + query
+}
@@ -0,0 +1,128 @@
+package goose
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+
+	"github.com/gotsunami/goose/goosetest"
+)
+
+// comment is a child of a post.
+type comment struct {
+	Id   string `json:"id"`
+	Post string `json:"post"`
+}
+
+func (c *comment) Key() string {
+	return c.Id
+}
+
+func (c *comment) Parent() string {
+	return c.Post
+}
+
+// invoice is stored on the shard of its customer.
+type invoice struct {
+	Id       string `json:"id"`
+	Customer string `json:"customer"`
+	Total    int    `json:"total"`
+}
+
+func (i *invoice) Key() string {
+	return i.Id
+}
+
+func (i *invoice) Routing() string {
+	return i.Customer
+}
+
+func TestRoutingParams(t *testing.T) {
+	tests := []struct {
+		version string
+		object  ElasticObject
+		should  string
+	}{
+		{"1.7.5", &comment{Post: "p1"}, "parent=p1"},
+		{"5.6.0", &comment{}, ""},
+		{"6.8.0", &comment{Post: "p1"}, "routing=p1"},
+		{"7.10.2", &invoice{Customer: "acme"}, "routing=acme"},
+		{"7.10.2", &invoice{}, ""},
+		{"7.10.2", &DummyObject{}, ""},
+	}
+	for _, test := range tests {
+		v, _ := ParseVersion(test.version)
+		params := make(url.Values)
+		if routingParams(v, test.object, params); params.Encode() != test.should {
+			t.Errorf("%s %+v: expected %q, got %q", test.version, test.object, test.should, params.Encode())
+		}
+	}
+	if q := searchRouting(&comment{Post: "p1"}); q != "routing=p1" {
+		t.Errorf("expected children to be searched on the shard of their parent, got %q", q)
+	}
+	if path := addQuery("/i/_search?size=0", "routing=a"); path != "/i/_search?size=0&routing=a" {
+		t.Errorf("bad query %s", path)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	forEachEngine(t, testVersions, func(t *testing.T, s *goosetest.Server, engine SearchEngine) {
+		es := engine.(*ElasticSearch)
+		v, _ := es.Version()
+		query, metaKey := "?routing=p1", `"routing":"acme"`
+		switch {
+		case v.Major < 6:
+			query, metaKey = "?parent=p1", `"_routing":"acme"`
+		case v.Major < 7:
+			metaKey = `"_routing":"acme"`
+		}
+		path, _ := es.docPath(v.Dialect(), &comment{Id: "c1"})
+		inv, _ := es.docPath(v.Dialect(), &invoice{Id: "i1"})
+
+		c := &comment{Id: "c1", Post: "p1"}
+		if err := es.Insert(c); err != nil {
+			t.Fatalf("Cannot insert: %v", err)
+		}
+		s.AssertRequested(t, "PUT", path+query)
+		if found, err := es.Get(c); !found || err != nil {
+			t.Errorf("Cannot get: %v", err)
+		}
+		s.AssertRequested(t, "GET", path+query)
+
+		i := &invoice{Id: "i1", Customer: "acme", Total: 10}
+		if err := es.Insert(i); err != nil {
+			t.Fatalf("Cannot insert: %v", err)
+		}
+		s.AssertRequested(t, "PUT", inv+"?routing=acme")
+		if err := es.UpdateWith(i, NewUpdateBuilder().SetFields("total")); err != nil {
+			t.Errorf("Cannot update: %v", err)
+		}
+		if _, err := es.Search(&invoice{Customer: "acme"}, NewQueryBuilder()); err != nil {
+			t.Errorf("Cannot search: %v", err)
+		}
+		if _, err := es.MultiGet([]ElasticObject{i}); err != nil {
+			t.Errorf("Cannot multi get: %v", err)
+		}
+		if _, err := es.Bulk(NewBulkBuilder().AddIndex(i)); err != nil {
+			t.Errorf("Cannot bulk: %v", err)
+		}
+		if err := es.Delete(i); err != nil {
+			t.Errorf("Cannot delete: %v", err)
+		}
+		s.AssertRequested(t, "DELETE", inv+"?routing=acme")
+
+		var routed []string
+		for _, r := range s.Requests() {
+			switch {
+			case r.Query.Get("routing") == "acme":
+				routed = append(routed, r.Method+" "+r.Path)
+			case bytes.Contains(r.Body, []byte(`"routing":"acme"`)) || bytes.Contains(r.Body, []byte(metaKey)):
+				routed = append(routed, r.Method+" "+r.Path+" body")
+			}
+		}
+		// insert, update, search, mget, bulk and delete
+		if len(routed) != 6 {
+			t.Errorf("expected 6 routed requests, got %v", routed)
+		}
+	}, perTypeIndex)
+}
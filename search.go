@@ -1,8 +1,8 @@
 package goose
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"reflect"
 	"strings"
 )
@@ -14,15 +14,24 @@ type Count struct {
 // Performs a "real" count
 // returns {"count":10124,"_shards":{"total":5,"successful":5,"failed":0}}
 func (se *ElasticSearch) Count(object ElasticObject) (int, error) {
-	path, err := buildPath(object)
+	return se.CountContext(context.Background(), object)
+}
+
+// CountContext is like Count but uses ctx to bound the request.
+func (se *ElasticSearch) CountContext(ctx context.Context, object ElasticObject) (int, error) {
+	d, err := se.dialect(ctx)
 	if err != nil {
 		return 0, err
 	}
-	resp, err := se.sendRequestAndGetResponse(GET, se.serverUrl+se.basePath+path+actionCount, nil)
-	defer resp.Body.Close()
+	path, err := se.typePath(d, object)
 	if err != nil {
 		return 0, err
 	}
+	resp, err := se.sendRequestAndGetResponse(ctx, "Count", GET, addQuery(path+actionCount, searchRouting(object)), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
 
 	dec := json.NewDecoder(resp.Body)
 
@@ -35,13 +44,23 @@ func (se *ElasticSearch) Count(object ElasticObject) (int, error) {
 }
 
 // Performs a search count
-func (se *ElasticSearch) SearchCount(object ElasticObject, qb *QueryBuilder) (*resultSet, error) {
-	return se.search(object, qb, typeCount)
+func (se *ElasticSearch) SearchCount(object ElasticObject, qb *QueryBuilder) (*ResultSet, error) {
+	return se.SearchCountContext(context.Background(), object, qb)
+}
+
+// SearchCountContext is like SearchCount but uses ctx to bound the request.
+func (se *ElasticSearch) SearchCountContext(ctx context.Context, object ElasticObject, qb *QueryBuilder) (*ResultSet, error) {
+	return se.search(ctx, object, qb, typeCount)
 }
 
 // Performs a search
-func (se *ElasticSearch) Search(object ElasticObject, qb *QueryBuilder) (*resultSet, error) {
-	return se.search(object, qb, typeSearch)
+func (se *ElasticSearch) Search(object ElasticObject, qb *QueryBuilder) (*ResultSet, error) {
+	return se.SearchContext(context.Background(), object, qb)
+}
+
+// SearchContext is like Search but uses ctx to bound the request.
+func (se *ElasticSearch) SearchContext(ctx context.Context, object ElasticObject, qb *QueryBuilder) (*ResultSet, error) {
+	return se.search(ctx, object, qb, typeSearch)
 }
 
 // performs a search of ElasticObjects with the QueryBuilder matching query and the search
@@ -50,40 +69,65 @@ func (se *ElasticSearch) Search(object ElasticObject, qb *QueryBuilder) (*result
 // This is the recommended method to make a search.
 // The QueryBuilder is easy to use and handles a lot of exceptions that could provoke
 // an ES failure
-func (se *ElasticSearch) search(object ElasticObject, qb *QueryBuilder, stype string) (*resultSet, error) {
-	se.stype = stype
-	var err error
+func (se *ElasticSearch) search(ctx context.Context, object ElasticObject, qb *QueryBuilder, stype string) (*ResultSet, error) {
+	d, err := se.dialect(ctx)
+	if err != nil {
+		return nil, err
+	}
 	jsondata := ""
 	if qb != nil {
-		if jsondata, err = qb.ToJSON(); err != nil {
+		if jsondata, err = qb.ToJSONDialect(d); err != nil {
 			return nil, err
 		}
 	}
 
-	return se.SearchRawJSON(object, jsondata)
+	return se.searchRawJSON(ctx, object, jsondata, stype)
 }
 
 // performs a search with a (supposedly) valid json string.
 // It is strongly adviced not to used this method except if you know exactly
 // what you are doing and/or if the QueryBuilder is missing the filter you want
-func (se *ElasticSearch) SearchRawJSON(object ElasticObject, jsondata string) (*resultSet, error) {
-	path, err := buildPath(object)
+func (se *ElasticSearch) SearchRawJSON(object ElasticObject, jsondata string) (*ResultSet, error) {
+	return se.SearchRawJSONContext(context.Background(), object, jsondata)
+}
+
+// SearchRawJSONContext is like SearchRawJSON but uses ctx to bound the
+// request.
+func (se *ElasticSearch) SearchRawJSONContext(ctx context.Context, object ElasticObject, jsondata string) (*ResultSet, error) {
+	return se.searchRawJSON(ctx, object, jsondata, typeSearch)
+}
+
+// performs a search with a json string and the search type given. The search
+// type is passed along rather than stored in se so that concurrent searches
+// do not interfere.
+func (se *ElasticSearch) searchRawJSON(ctx context.Context, object ElasticObject, jsondata string, stype string) (*ResultSet, error) {
+	d, err := se.dialect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	path, err := se.typePath(d, object)
 	if err != nil {
 		return nil, err
 	}
 
+	op := "Search"
+	if stype == typeCount {
+		op = "SearchCount"
+		// search_type=count was removed in ES 5.x
+		if d >= DIALECT_5X {
+			stype = typeSize0
+		}
+	}
 	body := strings.NewReader(jsondata)
-	resp, err := se.sendRequestAndGetResponse(GET, se.serverUrl+se.basePath+path+actionSearch+se.stype, body)
-	defer resp.Body.Close()
+	path = addQuery(path+actionSearch+stype, searchRouting(object))
+	resp, err := se.sendRequestAndGetResponse(ctx, op, GET, path, body)
 	if err != nil {
 		return nil, err
 	}
-	if resp == nil {
-		return nil, errors.New("No response from ES server")
-	}
+	defer resp.Body.Close()
 	dec := json.NewDecoder(resp.Body)
 
-	var rset = new(resultSet)
+	var rset = new(ResultSet)
 	err = dec.Decode(rset)
 	if err != nil {
 		return nil, err
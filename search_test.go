@@ -57,7 +57,9 @@ func TestSearch(t *testing.T) {
 			t.Error("Cannot insert dummy object: %v", err)
 		}
 	}
-	time.Sleep(1 * time.Second)
+	if err := es.Refresh(); err != nil {
+		t.Fatal("Cannot refresh index:", err)
+	}
 
 	rset, err := es.Search(&dummySet[0], nil)
 	if err != nil {
@@ -0,0 +1,74 @@
+package goose
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Trace describes an HTTP request sent to ES. A retried request produces
+// one trace per attempt.
+type Trace struct {
+	Method   HttpMethod
+	Url      string
+	Body     []byte
+	Attempt  int           // Starts at 1
+	Status   int           // HTTP status code, 0 if no response was received
+	Duration time.Duration // Time to get the response headers
+	Err      error         // Error returned by the HTTP client, if any
+}
+
+// Tracer is notified before each request sent to ES, and once its response
+// is received or the request failed.
+type Tracer interface {
+	Request(*Trace)
+	Response(*Trace)
+}
+
+// CurlCommand returns a curl command line equivalent to the request of t,
+// i.e
+//
+//	curl -XGET 'http://localhost:9200/gooseindex/goose__dummyobject/_search' -H 'Content-Type: application/json' -d '{"query":{"match_all":{}}}';echo
+func CurlCommand(t *Trace) string {
+	cmd := fmt.Sprintf("curl -X%s %s", t.Method, shellQuote(t.Url))
+	if len(t.Body) > 0 {
+		// ES 6.x and later reject the form content type curl sends by default
+		cmd += " -H 'Content-Type: application/json' -d " + shellQuote(string(t.Body))
+	}
+	return cmd + ";echo"
+}
+
+// shellQuote single-quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// LogTracer is a Tracer writing requests and responses to a logger.
+type LogTracer struct {
+	Logger *log.Logger // log.Default() if nil
+	Curl   bool        // Logs requests as curl commands
+}
+
+func (lt *LogTracer) logger() *log.Logger {
+	if lt.Logger == nil {
+		return log.Default()
+	}
+	return lt.Logger
+}
+
+func (lt *LogTracer) Request(t *Trace) {
+	if lt.Curl {
+		lt.logger().Print(CurlCommand(t))
+		return
+	}
+	lt.logger().Printf("%s %s %s", t.Method, t.Url, t.Body)
+}
+
+func (lt *LogTracer) Response(t *Trace) {
+	if t.Err != nil {
+		lt.logger().Printf("%s %s failed after %v: %v", t.Method, t.Url, t.Duration, t.Err)
+		return
+	}
+	lt.logger().Printf("%s %s: %d in %v", t.Method, t.Url, t.Status, t.Duration)
+}
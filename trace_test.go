@@ -0,0 +1,65 @@
+package goose
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingTracer struct {
+	requests, responses []Trace
+}
+
+func (rt *recordingTracer) Request(t *Trace)  { rt.requests = append(rt.requests, *t) }
+func (rt *recordingTracer) Response(t *Trace) { rt.responses = append(rt.responses, *t) }
+
+func TestTracer(t *testing.T) {
+	calls := 0
+	ts := newFlakyServer(http.StatusServiceUnavailable, 1, &calls)
+	defer ts.Close()
+
+	tracer := new(recordingTracer)
+	u, _ := url.Parse(ts.URL + "/" + index)
+	p := NewRetryPolicy(2)
+	p.InitialBackoff, p.MaxBackoff = time.Millisecond, time.Millisecond
+	es, _ := NewElasticSearch(u, WithVersion(testVersion), WithIndexCreation(false), WithTracer(tracer), WithRetryPolicy(p))
+	es.Insert(&DummyObject{Id: 1})
+
+	if len(tracer.requests) != 2 || len(tracer.responses) != 2 {
+		t.Fatalf("expected 2 traced attempts, got %d requests and %d responses", len(tracer.requests), len(tracer.responses))
+	}
+	for i, r := range tracer.responses {
+		if r.Method != PUT || !strings.HasPrefix(r.Url, ts.URL+"/"+index+"/") || !strings.HasSuffix(r.Url, "/1") || r.Attempt != i+1 {
+			t.Errorf("wrong trace for attempt %d: %v %v (attempt %d)", i+1, r.Method, r.Url, r.Attempt)
+		}
+		if string(r.Body) != `{"id":1,"description":"","len":0,"hq":{"lat":0,"lon":0}}` {
+			t.Errorf("wrong traced body %s", r.Body)
+		}
+	}
+	if tracer.responses[0].Status != 503 || tracer.responses[1].Status != 200 {
+		t.Errorf("wrong traced status codes %d, %d", tracer.responses[0].Status, tracer.responses[1].Status)
+	}
+}
+
+func TestCurlCommand(t *testing.T) {
+	tr := &Trace{
+		Method: GET,
+		Url:    "http://localhost:9200/gooseindex/goose__dummyobject/_search",
+		Body:   []byte(`{"query":{"term":{"description":"it's"}}}`),
+	}
+	should := `curl -XGET 'http://localhost:9200/gooseindex/goose__dummyobject/_search' -H 'Content-Type: application/json' -d '{"query":{"term":{"description":"it'\''s"}}}';echo`
+	if r := CurlCommand(tr); r != should {
+		t.Errorf("wrong curl command. Expected\n%v\ngot\n%v", should, r)
+	}
+
+	var buf bytes.Buffer
+	lt := &LogTracer{Logger: log.New(&buf, "", 0), Curl: true}
+	lt.Request(&Trace{Method: PUT, Url: "http://localhost:9200/gooseindex/_doc/1?op_type=create&refresh=wait_for"})
+	if r := buf.String(); r != "curl -XPUT 'http://localhost:9200/gooseindex/_doc/1?op_type=create&refresh=wait_for';echo\n" {
+		t.Errorf("wrong logged request %q", r)
+	}
+}
@@ -0,0 +1,305 @@
+package goose
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/gotsunami/goose/internal/dsl"
+)
+
+// Script is a script run by ES, i.e to update a document.
+type Script struct {
+	Source string // i.e ctx._source.count += params.inc
+	Params M      // parameters of the script, if any
+	Lang   string // language of the script, the default one of ES if empty
+}
+
+// UpdateBuilder has helper functions to build requests of the ES _update
+// API. By default, the object given to UpdateWith is merged into the
+// existing document, which must exist.
+//
+// For example, the following builder increments a counter, creating the
+// document if it does not exist:
+//
+//	ub := NewUpdateBuilder().
+//	    SetScript("ctx._source.count += params.inc", M{"inc": 1}).
+//	    SetUpsert(M{"count": 1})
+//
+// Partial updates, which only send some fields of the object, keep the
+// other fields from being overwritten with stale or zero values, i.e when
+// several writers change different fields of a document:
+//
+//	ub := NewUpdateBuilder().SetFields("country")
+//	ub := NewUpdateBuilder().SetSnapshot(snapshot) // the fields changed since
+type UpdateBuilder struct {
+	doc             interface{}
+	fields          []string
+	snapshot        interface{}
+	script          *Script
+	upsert          interface{}
+	docAsUpsert     bool
+	retryOnConflict int
+	returnSource    bool
+	refresh         RefreshPolicy
+}
+
+// Returns a pointer to a new UpdateBuilder
+func NewUpdateBuilder() *UpdateBuilder {
+	return new(UpdateBuilder)
+}
+
+// SetDoc sets the partial document merged into the existing one instead of
+// the updated object.
+func (ub *UpdateBuilder) SetDoc(doc interface{}) *UpdateBuilder {
+	ub.doc = doc
+	return ub
+}
+
+// SetFields makes the update only send the given fields of the updated
+// object, by JSON name. Selected fields missing from the JSON encoding of the
+// object, like empty omitempty fields, are set to null.
+func (ub *UpdateBuilder) SetFields(fields ...string) *UpdateBuilder {
+	ub.fields = fields
+	return ub
+}
+
+// SetSnapshot makes the update only send the fields of the updated object
+// which differ from the ones of snapshot, usually a copy of the object made
+// when it was read. Objects are compared recursively, and fields removed
+// since the snapshot are set to null.
+func (ub *UpdateBuilder) SetSnapshot(snapshot interface{}) *UpdateBuilder {
+	ub.snapshot = snapshot
+	return ub
+}
+
+// SetScript makes the update run the script source with params instead of
+// merging a partial document. Scripts are written in the default language of
+// the cluster: painless since ES 5.x, groovy before, where parameters are
+// referred to without the params prefix.
+func (ub *UpdateBuilder) SetScript(source string, params M) *UpdateBuilder {
+	ub.script = &Script{Source: source, Params: params}
+	return ub
+}
+
+// SetScriptLang sets the language of the script set with SetScript.
+func (ub *UpdateBuilder) SetScriptLang(lang string) *UpdateBuilder {
+	if ub.script != nil {
+		ub.script.Lang = lang
+	}
+	return ub
+}
+
+// SetUpsert sets the document indexed if the updated document does not
+// exist.
+func (ub *UpdateBuilder) SetUpsert(doc interface{}) *UpdateBuilder {
+	ub.upsert = doc
+	return ub
+}
+
+// SetDocAsUpsert makes the partial document be indexed if the updated
+// document does not exist. It does not apply to scripted updates.
+func (ub *UpdateBuilder) SetDocAsUpsert(docAsUpsert bool) *UpdateBuilder {
+	ub.docAsUpsert = docAsUpsert
+	return ub
+}
+
+// SetRetryOnConflict makes ES retry the update up to n times if the
+// document changes between the time it is read and the time it is written.
+// It cannot be combined with the version of Versioned objects: updates of
+// Versioned objects whose version is known fail before being sent.
+func (ub *UpdateBuilder) SetRetryOnConflict(n int) *UpdateBuilder {
+	ub.retryOnConflict = n
+	return ub
+}
+
+// SetReturnSource makes UpdateWith decode the updated document into the
+// updated object.
+func (ub *UpdateBuilder) SetReturnSource(returnSource bool) *UpdateBuilder {
+	ub.returnSource = returnSource
+	return ub
+}
+
+// SetRefresh sets the refresh policy of the update, the default one of the
+// instance if not set. The refresh policy of a bulk request applies to its
+// update actions instead.
+func (ub *UpdateBuilder) SetRefresh(p RefreshPolicy) *UpdateBuilder {
+	ub.refresh = p
+	return ub
+}
+
+// body returns the script of an update in the format of ES version v.
+func (s *Script) body(v Version) M {
+	script := M{}
+	if len(s.Params) > 0 {
+		script["params"] = s.Params
+	}
+	if s.Lang != "" {
+		script["lang"] = s.Lang
+	}
+	switch {
+	case v.Major <= 1:
+		// the script, its parameters and its language are top level fields
+		script["script"] = s.Source
+		return script
+	case v.Major <= 5:
+		script["inline"] = s.Source
+	default:
+		script["source"] = s.Source
+	}
+	return M{"script": script}
+}
+
+// partialDoc returns the partial document merged into the document of
+// object: the document set with SetDoc, or the fields of object selected
+// with SetFields or changed since the snapshot set with SetSnapshot.
+func (ub *UpdateBuilder) partialDoc(object ElasticObject) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if ub.doc != nil {
+		return doc, roundTrip(ub.doc, &doc)
+	}
+	if err := roundTrip(object, &doc); err != nil {
+		return nil, err
+	}
+	if ub.fields != nil {
+		selected := make(map[string]interface{}, len(ub.fields))
+		for _, field := range ub.fields {
+			selected[field] = doc[field]
+		}
+		doc = selected
+	}
+	if ub.snapshot != nil {
+		var old map[string]interface{}
+		if err := roundTrip(ub.snapshot, &old); err != nil {
+			return nil, err
+		}
+		if ub.fields != nil {
+			for k := range old {
+				if _, ok := doc[k]; !ok {
+					delete(old, k)
+				}
+			}
+		}
+		doc = dsl.Diff(old, doc)
+	}
+	return doc, nil
+}
+
+// roundTrip decodes the JSON encoding of v into dst.
+func roundTrip(v, dst interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// body returns the body of the update of object in the format of ES version
+// v.
+func (ub *UpdateBuilder) body(v Version, object ElasticObject) (M, error) {
+	body := M{}
+	if ub.script != nil {
+		body = ub.script.body(v)
+	} else {
+		doc, err := ub.partialDoc(object)
+		if err != nil {
+			return nil, err
+		}
+		body["doc"] = doc
+		if ub.docAsUpsert {
+			body["doc_as_upsert"] = true
+		}
+	}
+	if ub.upsert != nil {
+		body["upsert"] = ub.upsert
+	}
+	return body, nil
+}
+
+// checkVersioned returns an error if the update retries on conflicts while
+// object is Versioned and its version is known, which ES rejects.
+func (ub *UpdateBuilder) checkVersioned(object ElasticObject) error {
+	vo, ok := object.(Versioned)
+	if !ok || ub.retryOnConflict == 0 {
+		return nil
+	}
+	if dv := vo.DocVersion(); dv.Version > 0 || dv.PrimaryTerm > 0 {
+		return errors.New("retry on conflict cannot be combined with the version of Versioned objects")
+	}
+	return nil
+}
+
+// params returns the query parameters of the update for ES version v.
+func (ub *UpdateBuilder) params(v Version) url.Values {
+	params := make(url.Values)
+	if ub.retryOnConflict > 0 {
+		params.Set("retry_on_conflict", strconv.Itoa(ub.retryOnConflict))
+	}
+	if ub.returnSource {
+		if v.Major < 5 {
+			params.Set("fields", "_source")
+		} else {
+			params.Set("_source", "true")
+		}
+	}
+	return params
+}
+
+// updateResult is the reply of the _update API.
+type updateResult struct {
+	result
+	Get *struct {
+		Src json.RawMessage `json:"_source"`
+	} `json:"get"`
+}
+
+// updates an element in the index as defined by ub: the element can be
+// merged into the existing document, or the document updated with a script,
+// and optionally created if it does not exist. A nil ub merges object.
+// The update is conditioned on the version of Versioned objects.
+func (se *ElasticSearch) UpdateWith(object ElasticObject, ub *UpdateBuilder) error {
+	return se.UpdateWithContext(context.Background(), object, ub)
+}
+
+// UpdateWithContext is like UpdateWith but uses ctx to bound the request.
+func (se *ElasticSearch) UpdateWithContext(ctx context.Context, object ElasticObject, ub *UpdateBuilder) error {
+	if ub == nil {
+		ub = NewUpdateBuilder()
+	}
+	if err := ub.checkVersioned(object); err != nil {
+		return err
+	}
+	v, err := se.VersionContext(ctx)
+	if err != nil {
+		return err
+	}
+	path, err := se.updatePath(v.Dialect(), object)
+	if err != nil {
+		return err
+	}
+	body, err := ub.body(v, object)
+	if err != nil {
+		return err
+	}
+	jsondata, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	// the reply is only decoded when needed
+	var res *updateResult
+	var reply writeReply
+	if ub.returnSource {
+		res = new(updateResult)
+		reply = res
+	}
+	if err = se.sendWrite(ctx, "Update", POST, path, ub.params(v), ub.refresh, object, bytes.NewReader(jsondata), reply); err != nil {
+		return err
+	}
+	if res != nil && res.Get != nil {
+		return json.Unmarshal(res.Get.Src, object)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package goose
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/gotsunami/goose/goosetest"
+)
+
+type scoreboard struct {
+	Name   string   `json:"name"`
+	Points int      `json:"points"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+func (s *scoreboard) Key() string {
+	return s.Name
+}
+
+// testUpdateWith checks upserts, scripts and partial documents with engine.
+func testUpdateWith(t *testing.T, engine SearchEngine) {
+	inc := NewUpdateBuilder().
+		SetScript("ctx._source.points += params.inc", M{"inc": 2}).
+		SetUpsert(M{"name": "alice", "points": 1})
+	for _, should := range []int{1, 3} {
+		if err := engine.UpdateWith(&scoreboard{Name: "alice"}, inc); err != nil {
+			t.Fatalf("Cannot update with a script: %v", err)
+		}
+		sb := &scoreboard{Name: "alice"}
+		if _, err := engine.Get(sb); err != nil || sb.Points != should {
+			t.Errorf("expected %d points, got %d (%v)", should, sb.Points, err)
+		}
+	}
+
+	sb := &scoreboard{Name: "alice"}
+	ub := NewUpdateBuilder().SetScript("ctx._source.tags.add(params.tag)", M{"tag": "gold"}).SetReturnSource(true)
+	if err := engine.UpdateWith(sb, ub); err != nil {
+		t.Fatalf("Cannot update with a script: %v", err)
+	}
+	if should := (&scoreboard{"alice", 3, []string{"gold"}}); !reflect.DeepEqual(sb, should) {
+		t.Errorf("expected the returned source %+v, got %+v", should, sb)
+	}
+
+	if err := engine.UpdateWith(&scoreboard{Name: "bob", Points: 5}, NewUpdateBuilder().SetDocAsUpsert(true)); err != nil {
+		t.Fatalf("Cannot update as upsert: %v", err)
+	}
+	if err := engine.UpdateWith(&scoreboard{Name: "bob"}, NewUpdateBuilder().SetDoc(M{"tags": []string{"new"}})); err != nil {
+		t.Fatalf("Cannot update with a partial document: %v", err)
+	}
+	sb = &scoreboard{Name: "bob"}
+	if _, err := engine.Get(sb); err != nil || !reflect.DeepEqual(sb, &scoreboard{"bob", 5, []string{"new"}}) {
+		t.Errorf("bad partial update, got %+v (%v)", sb, err)
+	}
+
+	if err := engine.UpdateWith(&scoreboard{Name: "carol"}, NewUpdateBuilder().SetDoc(M{"points": 1})); !IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+	// concurrent partial updates of different fields
+	if err := engine.Insert(&scoreboard{"dave", 1, []string{"a"}}); err != nil {
+		t.Fatalf("Cannot insert: %v", err)
+	}
+	w1, w2 := &scoreboard{Name: "dave"}, &scoreboard{Name: "dave"}
+	engine.Get(w1)
+	engine.Get(w2)
+	snapshot := *w1
+	w1.Points = 10
+	if err := engine.UpdateWith(w1, NewUpdateBuilder().SetSnapshot(&snapshot)); err != nil {
+		t.Fatalf("Cannot update the changed fields: %v", err)
+	}
+	w2.Tags = []string{"b"}
+	if err := engine.UpdateWith(w2, NewUpdateBuilder().SetFields("tags")); err != nil {
+		t.Fatalf("Cannot update fields: %v", err)
+	}
+	sb = &scoreboard{Name: "dave"}
+	if _, err := engine.Get(sb); err != nil || !reflect.DeepEqual(sb, &scoreboard{"dave", 10, []string{"b"}}) {
+		t.Errorf("bad partial updates, got %+v (%v)", sb, err)
+	}
+
+	ub = NewUpdateBuilder().SetScript("System.exit(0)", nil)
+	if err, ok := engine.UpdateWith(&scoreboard{Name: "bob"}, ub).(*Error); !ok || err.Status != 400 {
+		t.Errorf("expected a bad request error, got %v", err)
+	}
+}
+
+func TestUpdateWith(t *testing.T) {
+	forEachEngine(t, testEngines, func(t *testing.T, s *goosetest.Server, engine SearchEngine) {
+		testUpdateWith(t, engine)
+		ub := NewUpdateBuilder().SetDoc(M{"points": 0}).SetRetryOnConflict(3)
+		if err := engine.UpdateWith(&scoreboard{Name: "bob"}, ub); err != nil {
+			t.Errorf("Cannot update with retries: %v", err)
+		}
+
+		// retries on conflict and versions cannot be combined
+		v := &versionedObject{Id: 1, Title: "v"}
+		if err := engine.Insert(v); err != nil {
+			t.Fatalf("Cannot insert: %v", err)
+		}
+		if s != nil {
+			s.ClearRequests()
+		}
+		if err := engine.UpdateWith(v, ub); err == nil || IsConflict(err) {
+			t.Errorf("expected an error before sending the update, got %v", err)
+		}
+		if _, err := engine.Bulk(NewBulkBuilder().AddUpdate(v, ub)); err == nil {
+			t.Error("expected an error before sending the bulk update")
+		}
+		if s != nil && len(s.Requests()) > 0 {
+			t.Errorf("unexpected requests %v", s.Requests())
+		}
+		if err := engine.UpdateWith(&versionedObject{Id: 1}, ub); err != nil {
+			t.Errorf("Cannot update with retries an object of unknown version: %v", err)
+		}
+	}, perTypeIndex)
+}
+
+func TestUpdateBody(t *testing.T) {
+	ub := NewUpdateBuilder().SetScript("ctx._source.n += params.n", M{"n": 1}).SetScriptLang("painless")
+	tests := []struct {
+		version Version
+		ub      *UpdateBuilder
+		body    string
+		params  string
+	}{
+		{Version{Major: 1}, ub,
+			`{"lang":"painless","params":{"n":1},"script":"ctx._source.n += params.n"}`, ""},
+		{Version{Major: 5}, ub,
+			`{"script":{"inline":"ctx._source.n += params.n","lang":"painless","params":{"n":1}}}`, ""},
+		{Version{Major: 7}, ub,
+			`{"script":{"lang":"painless","params":{"n":1},"source":"ctx._source.n += params.n"}}`, ""},
+		{Version{Major: 1}, NewUpdateBuilder().SetDocAsUpsert(true).SetReturnSource(true),
+			`{"doc":{"name":"a","points":0},"doc_as_upsert":true}`, "fields=_source"},
+		{Version{Major: 7}, NewUpdateBuilder().SetFields("points", "tags"),
+			`{"doc":{"points":0,"tags":null}}`, ""},
+		{Version{Major: 7}, NewUpdateBuilder().SetSnapshot(&scoreboard{Name: "a", Points: 2, Tags: []string{"x"}}),
+			`{"doc":{"points":0,"tags":null}}`, ""},
+		{Version{Major: 7}, NewUpdateBuilder().SetSnapshot(M{"name": "b", "points": 0}).SetFields("points"),
+			`{"doc":{}}`, ""},
+		{Version{Major: 7}, NewUpdateBuilder().SetDoc(M{"points": 1}).SetUpsert(M{}).SetRetryOnConflict(2).SetReturnSource(true),
+			`{"doc":{"points":1},"upsert":{}}`, "_source=true&retry_on_conflict=2"},
+	}
+	for _, test := range tests {
+		m, _ := test.ub.body(test.version, &scoreboard{Name: "a"})
+		body, _ := json.Marshal(m)
+		if string(body) != test.body {
+			t.Errorf("%v: expected the body %s, got %s", test.version, test.body, body)
+		}
+		if params := test.ub.params(test.version).Encode(); params != test.params {
+			t.Errorf("%v: expected the parameters %q, got %q", test.version, test.params, params)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package goose
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version of an ES server.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseVersion parses version numbers like 1.7.5, 7.10.2 or 8.0.0-SNAPSHOT.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("invalid ES version %q", s)
+	}
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid ES version %q", s)
+		}
+		*numbers[i] = n
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Defines the dialects of the ES API. The query DSL, the mapping types and
+// the REST paths change with the major versions of ES.
+type Dialect int
+
+const (
+	DIALECT_1X = Dialect(iota) // filtered queries, facets, string fields, typed paths
+	DIALECT_2X                 // bool queries with filters, aggregations
+	DIALECT_5X                 // text and keyword fields, _delete_by_query (5.x to 7.x)
+	DIALECT_8X                 // typeless paths
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case DIALECT_1X:
+		return "1.x"
+	case DIALECT_2X:
+		return "2.x"
+	case DIALECT_5X:
+		return "5.x-7.x"
+	}
+	return "8.x"
+}
+
+// Dialect returns the dialect spoken by ES servers of version v.
+func (v Version) Dialect() Dialect {
+	switch {
+	case v.Major <= 1:
+		return DIALECT_1X
+	case v.Major < 5:
+		return DIALECT_2X
+	case v.Major < 8:
+		return DIALECT_5X
+	}
+	return DIALECT_8X
+}
+
+// Version returns the version of the ES cluster, which is detected with a
+// GET / request the first time it is needed unless given with WithVersion.
+func (se *ElasticSearch) Version() (Version, error) {
+	return se.VersionContext(context.Background())
+}
+
+// versionCall is a detection of the version of the cluster. Concurrent
+// callers share it instead of sending their own GET / request.
+type versionCall struct {
+	done chan struct{} // closed once v and err are set
+	v    Version
+	err  error
+}
+
+// VersionContext is like Version but uses ctx to bound the request. Callers
+// waiting for a detection started by another one return ctx.Err() as soon as
+// ctx is done.
+func (se *ElasticSearch) VersionContext(ctx context.Context) (Version, error) {
+	for {
+		se.versionMu.Lock()
+		if se.version != nil {
+			v := *se.version
+			se.versionMu.Unlock()
+			return v, nil
+		}
+		call := se.versionCall
+		if call == nil {
+			call = &versionCall{done: make(chan struct{})}
+			se.versionCall = call
+			se.versionMu.Unlock()
+			call.v, call.err = se.detectVersion(ctx)
+			se.versionMu.Lock()
+			if call.err == nil {
+				se.version = &call.v
+			}
+			se.versionCall = nil
+			se.versionMu.Unlock()
+			close(call.done)
+			return call.v, call.err
+		}
+		se.versionMu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return Version{}, ctx.Err()
+		}
+		// a detection aborted by the context of its caller is started again
+		if call.err == nil || !errors.Is(call.err, context.Canceled) && !errors.Is(call.err, context.DeadlineExceeded) {
+			return call.v, call.err
+		}
+	}
+}
+
+// detectVersion gets the version of the cluster with a GET / request.
+func (se *ElasticSearch) detectVersion(ctx context.Context) (Version, error) {
+	resp, err := se.sendRequestAndGetResponse(ctx, "Version", GET, "/", nil)
+	if err != nil {
+		return Version{}, err
+	}
+	defer resp.Body.Close()
+	var info struct {
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return Version{}, err
+	}
+	if info.Version.Number == "" {
+		return Version{}, errors.New("no version number in ES reply")
+	}
+	v, err := ParseVersion(info.Version.Number)
+	if err != nil {
+		return Version{}, err
+	}
+	return v, nil
+}
+
+// dialect returns the dialect of the cluster, detecting its version if needed.
+func (se *ElasticSearch) dialect(ctx context.Context) (Dialect, error) {
+	v, err := se.VersionContext(ctx)
+	return v.Dialect(), err
+}
+
+// typePath returns the path where the objects of the type of object are
+// stored, with a trailing slash. Types are not part of the paths in ES 8.x
+// and later, where each type has its own index.
+func (se *ElasticSearch) typePath(d Dialect, object ElasticObject) (string, error) {
+	path, err := buildPath(object)
+	if err != nil {
+		return "", err
+	}
+	if d >= DIALECT_8X {
+		return se.typeIndex(strings.TrimSuffix(path, "/"))
+	}
+	return se.basePath + path, nil
+}
+
+// typeIndex returns the path of the index storing the objects of type typ
+// in ES 8.x and later, with a trailing slash. Without a resolver, the index
+// of the instance is bound to the first type used and other types are
+// refused, as their documents would be mixed.
+func (se *ElasticSearch) typeIndex(typ string) (string, error) {
+	se.indexMu.Lock()
+	defer se.indexMu.Unlock()
+	if se.indexResolver != nil {
+		name := se.indexResolver(typ)
+		if name == "" || strings.ContainsAny(name, "/,") {
+			return "", fmt.Errorf("invalid index %q for type %s", name, typ)
+		}
+		if se.typeIndices == nil {
+			se.typeIndices = make(map[string]bool)
+		}
+		se.typeIndices[name] = true
+		return "/" + name + "/", nil
+	}
+	if se.indexType == "" {
+		se.indexType = typ
+	}
+	if typ != se.indexType {
+		return "", fmt.Errorf("type %s cannot be stored in the index of type %s since ES 8.x, see WithIndexResolver", typ, se.indexType)
+	}
+	return se.basePath, nil
+}
+
+// indexName returns the name of the index storing the objects of the type of
+// object in ES 8.x and later.
+func (se *ElasticSearch) indexName(object ElasticObject) (string, error) {
+	path, err := se.typePath(DIALECT_8X, object)
+	return strings.Trim(path, "/"), err
+}
+
+// docPath returns the path of object.
+func (se *ElasticSearch) docPath(d Dialect, object ElasticObject) (string, error) {
+	path, err := se.typePath(d, object)
+	if err != nil {
+		return "", err
+	}
+	if d >= DIALECT_8X {
+		return path + "_doc/" + object.Key(), nil
+	}
+	return path + object.Key(), nil
+}
+
+// updatePath returns the path of the _update API for object.
+func (se *ElasticSearch) updatePath(d Dialect, object ElasticObject) (string, error) {
+	path, err := se.typePath(d, object)
+	if err != nil {
+		return "", err
+	}
+	if d >= DIALECT_8X {
+		return path + actionUpdate + "/" + object.Key(), nil
+	}
+	return path + strictSlash(object.Key()) + actionUpdate, nil
+}
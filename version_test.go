@@ -0,0 +1,262 @@
+package goose
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gotsunami/goose/goosetest"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		should  Version
+		dialect Dialect
+	}{
+		{"1.7.5", Version{1, 7, 5}, DIALECT_1X},
+		{"2.4", Version{2, 4, 0}, DIALECT_2X},
+		{"7.10.2", Version{7, 10, 2}, DIALECT_5X},
+		{"8.0.0-SNAPSHOT", Version{8, 0, 0}, DIALECT_8X},
+	}
+	for _, test := range tests {
+		v, err := ParseVersion(test.version)
+		if err != nil {
+			t.Errorf("Cannot parse %s: %v", test.version, err)
+			continue
+		}
+		if v != test.should || v.Dialect() != test.dialect {
+			t.Errorf("wrong version for %s. Expected %v (%v), got %v (%v)", test.version,
+				test.should, test.dialect, v, v.Dialect())
+		}
+	}
+	for _, bad := range []string{"", "7", "7.x", "1.2.3.4"} {
+		if _, err := ParseVersion(bad); err == nil {
+			t.Errorf("invalid version %q accepted", bad)
+		}
+	}
+}
+
+func TestVersionDetection(t *testing.T) {
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `{"version":{"number":"8.11.1"}}`)
+		case "/" + index + "/_doc/1":
+			fmt.Fprint(w, `{"_source":{"id":1}}`)
+		}
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithIndexCreation(false))
+	if _, err := es.Get(&DummyObject{Id: 1}); err != nil {
+		t.Fatal("Cannot get dummy object:", err)
+	}
+	if err := es.Insert(&DummyObject{Id: 1}); err != nil {
+		t.Fatal("Cannot insert dummy object:", err)
+	}
+	should := []string{"GET /", "GET /" + index + "/_doc/1", "PUT /" + index + "/_doc/1"}
+	if fmt.Sprint(paths) != fmt.Sprint(should) {
+		t.Errorf("wrong requests. Expected %v, got %v", should, paths)
+	}
+	if v, _ := es.Version(); v != (Version{8, 11, 1}) {
+		t.Errorf("wrong version %v", v)
+	}
+}
+
+func TestConcurrentVersionDetection(t *testing.T) {
+	var detections atomic.Int32
+	arrived, release := make(chan struct{}), make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			if detections.Add(1) == 1 {
+				close(arrived)
+			}
+			<-release
+			fmt.Fprint(w, `{"version":{"number":"7.10.2"}}`)
+		default:
+			fmt.Fprint(w, `{"count":1}`)
+		}
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/" + index)
+	es, _ := NewElasticSearch(u, WithIndexCreation(false))
+	first, second := make(chan error), make(chan error)
+	go func() {
+		_, err := es.Version()
+		first <- err
+	}()
+	<-arrived
+	go func() {
+		_, err := es.Version()
+		second <- err
+	}()
+
+	// a caller waiting for the detection is bounded by its own context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	counted := make(chan error, 1)
+	go func() {
+		_, err := es.CountContext(ctx, &DummyObject{})
+		counted <- err
+	}()
+	select {
+	case err := <-counted:
+		if err != context.DeadlineExceeded {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("waiting caller still blocked after 1s")
+	}
+
+	close(release)
+	for _, c := range []chan error{first, second} {
+		if err := <-c; err != nil {
+			t.Error("Cannot detect version:", err)
+		}
+	}
+	if n := detections.Load(); n != 1 {
+		t.Errorf("expected a single GET / request, got %d", n)
+	}
+	if v, _ := es.Version(); v != (Version{7, 10, 2}) {
+		t.Errorf("wrong version %v", v)
+	}
+}
+
+func TestQueryDialect(t *testing.T) {
+	qb := NewQueryBuilder().SetTerm("name", "montre").AddFuzzySearch("desc", "blue watch").
+		AddGeoDistance("hq", Location{dlat, dlong}, 10, KM).SetTermFacet("names", "name", 5, nil)
+	j, err := qb.ToJSONDialect(DIALECT_5X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var q struct {
+		Query struct {
+			Bool struct {
+				Must   []M
+				Should []M
+				Filter []M
+			}
+			Filtered M
+		}
+		Aggs   map[string]M
+		Facets M
+	}
+	if err = json.Unmarshal([]byte(j), &q); err != nil {
+		t.Fatal(err)
+	}
+	if q.Query.Filtered != nil || q.Facets != nil {
+		t.Error("1.x query in 5.x dialect:", j)
+	}
+	if len(q.Query.Bool.Must) != 1 || len(q.Query.Bool.Should) != 2 || len(q.Query.Bool.Filter) != 1 || q.Aggs["names"] == nil {
+		t.Error("wrong 5.x query:", j)
+	}
+	if _, ok := q.Query.Bool.Should[0]["match_phrase"]; !ok {
+		t.Error("phrase not converted to match_phrase:", j)
+	}
+
+	if j, _ = NewQueryBuilder().ToJSONDialect(DIALECT_8X); j != `{"size":10,"query":{"match_all":{}}}` {
+		t.Error("wrong empty query:", j)
+	}
+}
+
+func TestMappingDialect(t *testing.T) {
+	mb := NewMappingBuilder().AddMapping("name", TYPE_STRING).AddMapping("tag", TYPE_KEYWORD)
+	tests := []struct {
+		dialect Dialect
+		should  string
+	}{
+		{DIALECT_1X, `{"properties":{"name":{"type":"string"},"tag":{"index":"not_analyzed","type":"string"}}}`},
+		{DIALECT_5X, `{"properties":{"name":{"type":"text"},"tag":{"type":"keyword"}}}`},
+	}
+	for _, test := range tests {
+		j, err := mb.ToJSONDialect(test.dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j != test.should {
+			t.Errorf("wrong %v mapping. Expected %s, got %s", test.dialect, test.should, j)
+		}
+	}
+}
+
+func TestResultSetTotal(t *testing.T) {
+	const reply = `{"hits":{"total":{"value":42,"relation":"eq"},"hits":[]},
+		"aggregations":{"names":{"sum_other_doc_count":3,"buckets":[{"key":"montre","doc_count":2}]}}}`
+	var rs ResultSet
+	if err := json.Unmarshal([]byte(reply), &rs); err != nil {
+		t.Fatal(err)
+	}
+	if rs.Hits.Total != 42 {
+		t.Errorf("wrong total. Expected 42, got %d", rs.Hits.Total)
+	}
+	if f := rs.Facets["names"]; f.Total != 5 || len(f.Terms) != 1 {
+		t.Errorf("aggregation not converted to a facet: %+v", f)
+	}
+}
+
+func TestIndexResolver(t *testing.T) {
+	// without resolver, the index is bound to the first type used in ES 8.x
+	forEachEngine(t, []string{"7.10.2", "8.11.1"}, func(t *testing.T, s *goosetest.Server, engine SearchEngine) {
+		es := engine.(*ElasticSearch)
+		v, _ := es.Version()
+		if err := es.Insert(&DummyObject{Id: 1}); err != nil {
+			t.Fatal("Cannot insert:", err)
+		}
+		err := es.Insert(&versionedObject{Id: 1})
+		if v.Dialect() < DIALECT_8X {
+			if err != nil {
+				t.Error("Cannot insert:", err)
+			}
+			return
+		}
+		if err == nil {
+			t.Error("expected an error for a second type in the index")
+		}
+		if _, err = es.Bulk(NewBulkBuilder().AddIndex(&versionedObject{Id: 2})); err == nil {
+			t.Error("expected an error for a second type in the bulk")
+		}
+		if n, err := es.Count(&DummyObject{}); n != 1 || err != nil {
+			t.Errorf("expected 1 document, got %d (%v)", n, err)
+		}
+	})
+
+	forEachEngine(t, []string{"8.11.1"}, func(t *testing.T, s *goosetest.Server, engine SearchEngine) {
+		es := engine.(*ElasticSearch)
+		dummy, vo := &DummyObject{Id: 1, Description: "dummy"}, &versionedObject{Id: 1, Title: "versioned"}
+		if _, err := es.Bulk(NewBulkBuilder().AddIndex(dummy).AddIndex(vo)); err != nil {
+			t.Fatal("Cannot bulk:", err)
+		}
+		s.AssertDocument(t, index+"-dummyobject", "", "1", dummy)
+		s.AssertDocument(t, index+"-versionedobject", "", "1", vo)
+		results, err := es.MultiGet([]ElasticObject{&DummyObject{Id: 1}, &versionedObject{Id: 1}})
+		if err != nil {
+			t.Fatal("Cannot multi get:", err)
+		}
+		for i, r := range results {
+			if !r.Found || r.Err != nil {
+				t.Errorf("document %d not found: %v", i, r.Err)
+			}
+		}
+		if err := es.Refresh(); err != nil {
+			t.Error("Cannot refresh:", err)
+		}
+		s.AssertRequested(t, "POST", "/"+index+","+index+"-dummyobject,"+index+"-versionedobject/_refresh?ignore_unavailable=true")
+		for _, object := range []ElasticObject{&DummyObject{}, &versionedObject{}} {
+			if n, err := es.Count(object); n != 1 || err != nil {
+				t.Errorf("expected 1 document of %T, got %d (%v)", object, n, err)
+			}
+		}
+	}, perTypeIndex)
+}
@@ -0,0 +1,139 @@
+package goose
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+// DocVersion is the version of a document, which changes with every write.
+// ES 6.x and later also identify writes with a sequence number and a primary
+// term.
+type DocVersion struct {
+	Version     int64 // 0 if unknown
+	SeqNo       int64
+	PrimaryTerm int64 // 0 if the sequence number is unknown
+}
+
+// Versioned is implemented by the objects whose writes are conditioned on
+// the version of their document, for optimistic concurrency control: Insert,
+// Update and Delete fail with a conflict error (see IsConflict) if the
+// document changed since the object was read or written. The version of the
+// object is set after every successful Get, Insert, Update or Delete.
+//
+// Writes are conditioned with the if_seq_no and if_primary_term parameters
+// on ES 6.7 and later, and with the version parameter before. Objects with a
+// zero DocVersion are written unconditionally.
+//
+// Embedding a VersionTracker in an ElasticObject is the simplest way to
+// implement Versioned.
+type Versioned interface {
+	ElasticObject
+	DocVersion() DocVersion
+	SetDocVersion(DocVersion)
+}
+
+// VersionTracker implements Versioned when embedded in an ElasticObject.
+// The version is not part of the JSON document.
+type VersionTracker struct {
+	version DocVersion
+}
+
+// DocVersion returns the version of the document.
+func (vt *VersionTracker) DocVersion() DocVersion {
+	return vt.version
+}
+
+// SetDocVersion sets the version of the document.
+func (vt *VersionTracker) SetDocVersion(v DocVersion) {
+	vt.version = v
+}
+
+// writeReply is the reply of a write.
+type writeReply interface {
+	docVersion() DocVersion
+}
+
+// docVersion returns the version of the document of a reply.
+func (r *result) docVersion() DocVersion {
+	v := DocVersion{Version: int64(r.Version)}
+	if r.SeqNo != nil && r.PrimaryTerm != nil {
+		v.SeqNo, v.PrimaryTerm = *r.SeqNo, *r.PrimaryTerm
+	}
+	return v
+}
+
+// versionParams adds to params the parameters conditioning the write of
+// object on its version. Nothing is added if object is not Versioned or if
+// its version is unknown.
+func (se *ElasticSearch) versionParams(ctx context.Context, object ElasticObject, params url.Values) error {
+	vo, ok := object.(Versioned)
+	if !ok {
+		return nil
+	}
+	dv := vo.DocVersion()
+	if dv.PrimaryTerm > 0 {
+		v, err := se.VersionContext(ctx)
+		if err != nil {
+			return err
+		}
+		if v.Major > 6 || v.Major == 6 && v.Minor >= 7 {
+			params.Set("if_seq_no", strconv.FormatInt(dv.SeqNo, 10))
+			params.Set("if_primary_term", strconv.FormatInt(dv.PrimaryTerm, 10))
+			return nil
+		}
+	}
+	if dv.Version > 0 {
+		params.Set("version", strconv.FormatInt(dv.Version, 10))
+	}
+	return nil
+}
+
+// sendWrite sends a write request of object with the query parameters
+// params, which may be nil, the routing parameters of object and the refresh
+// parameter of the policy refresh. The write
+// is conditioned on the version of object if it is Versioned, unless it is
+// a create-only write, and the version of object is updated from the reply.
+// The reply is decoded into res if it is not nil. Create-only and
+// conditioned writes are not idempotent: once executed, they would fail
+// with a conflict if replayed.
+func (se *ElasticSearch) sendWrite(ctx context.Context, op string, m HttpMethod, path string, params url.Values, refresh RefreshPolicy, object ElasticObject, body io.Reader, res writeReply) error {
+	vo, versioned := object.(Versioned)
+	if params == nil {
+		params = make(url.Values)
+	}
+	if versioned && params.Get("op_type") != "create" {
+		if err := se.versionParams(ctx, object, params); err != nil {
+			return err
+		}
+	}
+	v, err := se.VersionContext(ctx)
+	if err != nil {
+		return err
+	}
+	idempotent := m != POST && params.Get("op_type") != "create" &&
+		params.Get("if_seq_no") == "" && params.Get("version") == ""
+	routingParams(v, object, params)
+	se.refreshParams(v, refresh, params, true)
+	path = addQuery(path, params.Encode())
+	resp, err := se.send(ctx, op, m, path, body, idempotent, se.retry)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !versioned && res == nil {
+		return nil
+	}
+	if res == nil {
+		res = new(result)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return err
+	}
+	if versioned {
+		vo.SetDocVersion(res.docVersion())
+	}
+	return nil
+}
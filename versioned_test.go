@@ -0,0 +1,107 @@
+package goose
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/gotsunami/goose/goosetest"
+)
+
+type versionedObject struct {
+	VersionTracker
+	Id    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+func (v *versionedObject) Key() string {
+	return fmt.Sprintf("%d", v.Id)
+}
+
+// testVersioning runs concurrent writes of the same document on engine.
+func testVersioning(t *testing.T, engine SearchEngine) {
+	a := &versionedObject{Id: 1, Title: "a"}
+	if err := engine.Insert(a); err != nil {
+		t.Fatalf("Cannot insert: %v", err)
+	}
+	if v := a.DocVersion(); v.Version != 1 {
+		t.Errorf("wrong version after insert %+v", v)
+	}
+	b := &versionedObject{Id: 1}
+	if _, err := engine.Get(b); err != nil || b.DocVersion() != a.DocVersion() || b.Title != "a" {
+		t.Fatalf("wrong document %+v (%v)", b, err)
+	}
+
+	a.Title = "a2"
+	if err := engine.Update(a); err != nil {
+		t.Fatalf("Cannot update: %v", err)
+	}
+	if v := a.DocVersion(); v.Version != 2 {
+		t.Errorf("wrong version after update %+v", v)
+	}
+	b.Title = "b"
+	if err := engine.Update(b); !IsConflict(err) {
+		t.Errorf("stale update: expected a conflict, got %v", err)
+	}
+	if err := engine.Insert(b); !IsConflict(err) {
+		t.Errorf("stale insert: expected a conflict, got %v", err)
+	}
+	if err := engine.Delete(b); !IsConflict(err) {
+		t.Errorf("stale delete: expected a conflict, got %v", err)
+	}
+
+	if _, err := engine.Get(b); err != nil || b.Title != "a2" {
+		t.Fatalf("wrong document %+v (%v)", b, err)
+	}
+	b.Title = "b"
+	if err := engine.Insert(b); err != nil {
+		t.Errorf("Cannot insert: %v", err)
+	}
+	if err := engine.Delete(a); !IsConflict(err) {
+		t.Errorf("stale delete: expected a conflict, got %v", err)
+	}
+	if err := engine.Delete(b); err != nil {
+		t.Errorf("Cannot delete: %v", err)
+	}
+
+	// objects with an unknown version are written unconditionally
+	if err := engine.Insert(&versionedObject{Id: 1, Title: "c"}); err != nil {
+		t.Errorf("Cannot insert: %v", err)
+	}
+}
+
+func TestVersioning(t *testing.T) {
+	forEachEngine(t, []string{"1.7.5", "6.0.0", "6.8.0", "7.10.2", "8.11.1", memoryEngine}, func(t *testing.T, _ *goosetest.Server, engine SearchEngine) {
+		testVersioning(t, engine)
+	})
+}
+
+func TestVersionParams(t *testing.T) {
+	tests := []struct {
+		version string
+		dv      DocVersion
+		should  string
+	}{
+		{"1.7.5", DocVersion{Version: 3}, "version=3"},
+		{"6.0.0", DocVersion{3, 5, 1}, "version=3"},
+		{"6.7.0", DocVersion{3, 5, 1}, "if_primary_term=1&if_seq_no=5"},
+		{"7.10.2", DocVersion{3, 0, 2}, "if_primary_term=2&if_seq_no=0"},
+		{"7.10.2", DocVersion{}, ""},
+	}
+	u, _ := url.Parse(uri + index)
+	for _, test := range tests {
+		es, _ := NewElasticSearch(u, WithIndexCreation(false), WithVersion(test.version))
+		o := &versionedObject{}
+		o.SetDocVersion(test.dv)
+		params := make(url.Values)
+		if err := es.versionParams(context.Background(), o, params); params.Encode() != test.should || err != nil {
+			t.Errorf("%s %+v: expected %q, got %q (%v)", test.version, test.dv, test.should, params.Encode(), err)
+		}
+	}
+	es, _ := NewElasticSearch(u, WithIndexCreation(false), WithVersion("7.10.2"))
+	params := make(url.Values)
+	if es.versionParams(context.Background(), &DummyObject{}, params); len(params) != 0 {
+		t.Errorf("unversioned object conditioned with %v", params)
+	}
+}